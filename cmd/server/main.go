@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/ledger"
+	"example.com/myapp/internal/logging"
+	"example.com/myapp/internal/metrics"
+	"example.com/myapp/internal/migrations"
+	"example.com/myapp/internal/mining"
+	"example.com/myapp/internal/server"
+	"example.com/myapp/internal/trade"
+	"github.com/jmoiron/sqlx"
+)
+
+const usage = `usage: server [--profile dev|prod] [--env-file FILE] [--listen ADDR] [command]
+
+commands:
+  serve          run the HTTP server (default)
+  up             apply pending migrations
+  down           revert the last applied migration
+  status         list migrations and whether they are applied
+  reconcile      compare user balances with the ledger
+  config print   show the effective configuration with secrets redacted`
+
+func main() {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	command := "serve"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "config":
+		if len(args) != 2 || args[1] != "print" {
+			log.Fatal(usage)
+		}
+		cfg.Print(os.Stdout)
+		if err := cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "serve", "up", "down", "status", "reconcile":
+	default:
+		log.Fatal(usage)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	db, err := database.Connect(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	switch command {
+	case "up", "down", "status":
+		err = migrations.Run(db, command, os.Stdout)
+	case "reconcile":
+		err = reconcile(db)
+	default:
+		err = serve(cfg, db)
+	}
+	if err != nil {
+		db.Close()
+		log.Fatal(err)
+	}
+}
+
+func serve(cfg config.Config, db *sqlx.DB) error {
+	pending, err := migrations.Pending(db)
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		slog.Warn("pending migrations, run with \"up\" to apply them", "count", pending)
+	}
+
+	ctx, stop := server.NotifyShutdown(context.Background())
+	defer stop()
+
+	if err := metrics.RegisterDB(db.DB, "mysql"); err != nil {
+		return err
+	}
+
+	health := server.NewHealth(db)
+	context.AfterFunc(ctx, health.SetShuttingDown)
+
+	router, err := server.Routes(cfg, db, health)
+	if err != nil {
+		return err
+	}
+
+	engineDone := make(chan struct{})
+	if cfg.Mining.Enabled {
+		engine := mining.NewEngine(database.NewMySQLStore(db), database.NewMySQLLock(db, "mining_engine"), cfg.Game, cfg.Mining.PollInterval)
+		go func() {
+			engine.Run(ctx)
+			close(engineDone)
+		}()
+	} else {
+		close(engineDone)
+	}
+
+	sweeperDone := make(chan struct{})
+	sweeper := trade.NewSweeper(database.NewMySQLStore(db), cfg.Trades.SweepInterval)
+	go func() {
+		sweeper.Run(ctx)
+		close(sweeperDone)
+	}()
+
+	slog.Info("server running", "addr", cfg.ListenAddr, "profile", cfg.Profile, "version", server.Version)
+	if err := server.Run(ctx, cfg.ListenAddr, cfg.HTTP, router); err != nil {
+		return err
+	}
+	<-engineDone
+	<-sweeperDone
+	slog.Info("server stopped")
+	return nil
+}
+
+func reconcile(db *sqlx.DB) error {
+	report, err := ledger.Reconcile(database.NewMySQLStore(db))
+	if err != nil {
+		return err
+	}
+
+	for _, d := range report.Drifts {
+		fmt.Printf("user %d %s: ledger %d, actual %d, drift %+d\n",
+			d.UserId, d.Currency, d.Expected, d.Actual, d.Amount())
+	}
+	for _, id := range report.UnbalancedTransfers {
+		fmt.Printf("transfer %s does not balance\n", id)
+	}
+	fmt.Printf("checked %d balances: %d drifted, %d unbalanced transfers\n",
+		report.Checked, len(report.Drifts), len(report.UnbalancedTransfers))
+
+	if len(report.Drifts) > 0 || len(report.UnbalancedTransfers) > 0 {
+		return fmt.Errorf("ledger does not reconcile")
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/myapp/internal/handlers/apierror"
+	"example.com/myapp/internal/logging"
+)
+
+const (
+	ReasonMissingInitData   = "missing_init_data"
+	ReasonMalformedInitData = "malformed_init_data"
+	ReasonInvalidSignature  = "invalid_signature"
+	ReasonExpired           = "expired"
+	ReasonMissingUser       = "missing_user"
+)
+
+type contextKey struct{}
+
+type Error struct {
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return "auth: " + e.Reason
+}
+
+type initDataUser struct {
+	Id int64 `json:"id"`
+}
+
+func Verify(initData, botToken string, maxAge time.Duration, now time.Time) (string, error) {
+	if initData == "" {
+		return "", &Error{Reason: ReasonMissingInitData}
+	}
+
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return "", &Error{Reason: ReasonMalformedInitData}
+	}
+
+	hash := values.Get("hash")
+	if hash == "" {
+		return "", &Error{Reason: ReasonMalformedInitData}
+	}
+	values.Del("hash")
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+values.Get(k))
+	}
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+
+	expected, err := hex.DecodeString(hash)
+	if err != nil || !hmac.Equal(mac.Sum(nil), expected) {
+		return "", &Error{Reason: ReasonInvalidSignature}
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return "", &Error{Reason: ReasonMalformedInitData}
+	}
+	if maxAge > 0 && now.Sub(time.Unix(authDate, 0)) > maxAge {
+		return "", &Error{Reason: ReasonExpired}
+	}
+
+	var user initDataUser
+	if err := json.Unmarshal([]byte(values.Get("user")), &user); err != nil || user.Id == 0 {
+		return "", &Error{Reason: ReasonMissingUser}
+	}
+
+	return strconv.FormatInt(user.Id, 10), nil
+}
+
+// Middleware rejects requests without valid initData and stores the verified
+// chat id in the request context. The initData is read from an
+// "Authorization: tma <initData>" header.
+func Middleware(botToken string, maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			chatId, err := Verify(initDataFromRequest(r), botToken, maxAge, time.Now())
+			if err != nil {
+				reason := ReasonInvalidSignature
+				var authErr *Error
+				if errors.As(err, &authErr) {
+					reason = authErr.Reason
+				}
+				logging.FromContext(r.Context()).Info("auth rejected", "reason", reason)
+				apierror.Write(w, r, apierror.ErrUnauthorized.WithDetails(map[string]any{
+					"reason": reason,
+				}))
+				return
+			}
+
+			logging.AddAttrs(r.Context(), "chat_id", chatId)
+			ctx := context.WithValue(r.Context(), contextKey{}, chatId)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func ChatId(ctx context.Context) string {
+	chatId, _ := ctx.Value(contextKey{}).(string)
+	return chatId
+}
+
+func initDataFromRequest(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	scheme, initData, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "tma") {
+		return ""
+	}
+	return strings.TrimSpace(initData)
+}
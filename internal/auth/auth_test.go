@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"example.com/myapp/internal/auth/authtest"
+)
+
+// signed returns initData for chat 42 issued at authDate.
+func signed(authDate time.Time) url.Values {
+	values := url.Values{}
+	values.Set("auth_date", strconv.FormatInt(authDate.Unix(), 10))
+	values.Set("query_id", "q1")
+	values.Set("user", `{"id":42}`)
+	return values
+}
+
+func TestMiddleware(t *testing.T) {
+	now := time.Now()
+	valid := authtest.Sign(signed(now), authtest.BotToken)
+
+	tampered, _ := url.ParseQuery(valid)
+	tampered.Set("user", `{"id":43}`)
+
+	badHash, _ := url.ParseQuery(valid)
+	badHash.Set("hash", "not-hex")
+
+	missingHash, _ := url.ParseQuery(valid)
+	missingHash.Del("hash")
+
+	noUser := signed(now)
+	noUser.Del("user")
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantChatId    string
+		wantReason    string
+	}{
+		{name: "valid", authorization: "tma " + valid, wantChatId: "42"},
+		{name: "scheme is case-insensitive", authorization: "TMA " + valid, wantChatId: "42"},
+		{name: "tampered field", authorization: "tma " + tampered.Encode(), wantReason: ReasonInvalidSignature},
+		{name: "other bot token", authorization: "tma " + authtest.Sign(signed(now), "other-token"), wantReason: ReasonInvalidSignature},
+		{name: "hash not hex", authorization: "tma " + badHash.Encode(), wantReason: ReasonInvalidSignature},
+		{name: "expired auth_date", authorization: "tma " + authtest.Sign(signed(now.Add(-2*time.Hour)), authtest.BotToken), wantReason: ReasonExpired},
+		{name: "missing hash", authorization: "tma " + missingHash.Encode(), wantReason: ReasonMalformedInitData},
+		{name: "missing user", authorization: "tma " + authtest.Sign(noUser, authtest.BotToken), wantReason: ReasonMissingUser},
+		{name: "no header", wantReason: ReasonMissingInitData},
+		{name: "wrong scheme", authorization: "Bearer " + valid, wantReason: ReasonMissingInitData},
+		{name: "scheme only", authorization: "tma", wantReason: ReasonMissingInitData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotChatId string
+			h := Middleware(authtest.BotToken, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotChatId = ChatId(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if tt.wantReason == "" {
+				if w.Code != http.StatusOK || gotChatId != tt.wantChatId {
+					t.Fatalf("status %d, chat id %q, want 200 and %q: %s", w.Code, gotChatId, tt.wantChatId, w.Body)
+				}
+				return
+			}
+
+			if gotChatId != "" {
+				t.Error("rejected request reached the handler")
+			}
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want 401", w.Code)
+			}
+			var body struct {
+				Code    string `json:"code"`
+				Details struct {
+					Reason string `json:"reason"`
+				} `json:"details"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode %q: %v", w.Body, err)
+			}
+			if body.Code != "unauthorized" || body.Details.Reason != tt.wantReason {
+				t.Errorf("got code %q reason %q, want unauthorized and %q", body.Code, body.Details.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestVerifyMaxAge(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	initData := authtest.Sign(signed(now.Add(-time.Minute)), authtest.BotToken)
+
+	tests := []struct {
+		name    string
+		maxAge  time.Duration
+		wantErr bool
+	}{
+		{"within max age", 2 * time.Minute, false},
+		{"exactly max age", time.Minute, false},
+		{"past max age", 30 * time.Second, true},
+		{"no max age", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chatId, err := Verify(initData, authtest.BotToken, tt.maxAge, now)
+			if tt.wantErr {
+				if authErr, ok := err.(*Error); !ok || authErr.Reason != ReasonExpired {
+					t.Errorf("got %v, want %s", err, ReasonExpired)
+				}
+				return
+			}
+			if err != nil || chatId != "42" {
+				t.Errorf("got %q, %v, want 42", chatId, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,575 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+type Config struct {
+	Profile        string
+	ListenAddr     string
+	BotToken       string
+	AuthMaxAge     time.Duration
+	IdempotencyTTL time.Duration
+	CORS           CORS
+	LogLevel       string
+	LogFormat      string
+	RateLimit      RateLimit
+	Mining         Mining
+	Trades         Trades
+	HTTP           HTTP
+	Database       Database
+	Game           Game
+}
+
+type HTTP struct {
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+}
+
+type CORS struct {
+	Origins          []string
+	Methods          []string
+	Headers          []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// RateLimit limits every client address before authentication with IP,
+// then each user per route group with Read and Economy.
+type RateLimit struct {
+	TrustedProxies []netip.Prefix
+	IP             Limit
+	Read           Limit
+	Economy        Limit
+}
+
+// Limit allows Rate requests per second on average with bursts of up to
+// Burst requests.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type Mining struct {
+	Enabled      bool
+	PollInterval time.Duration
+}
+
+type Trades struct {
+	SweepInterval time.Duration
+}
+
+type Database struct {
+	User            string
+	Password        string
+	Host            string
+	Port            int
+	Name            string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	DialTimeout     time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+}
+
+type Game struct {
+	SlotPrice      int64
+	MaxSlots       int
+	FreezeFuel     int
+	MaxFuel        int
+	IncomePerLevel float32
+	MiningTick     time.Duration
+	FuelPerTick    int
+	FuelPerLevel   int
+	MaxLevel       int
+	UpgradeCosts   []UpgradeCost
+	// FuseCards is the fewest cards a fusion takes.
+	FuseCards      int
+	FuseChance     float64
+	MarketFee      float64
+	TradeTTL       time.Duration
+	CaseGemsMin    int
+	CaseGemsMax    int
+	CaseBalanceMin int
+	CaseBalanceMax int
+}
+
+// UpgradeCost is the price of one level. UpgradeCosts[i] takes a card from
+// level i+1 to i+2; level 0 cards count as level 1.
+type UpgradeCost struct {
+	Currency string
+	Amount   int64
+}
+
+func (c UpgradeCost) String() string {
+	return c.Currency + ":" + strconv.FormatInt(c.Amount, 10)
+}
+
+type profile struct {
+	envFile     string
+	listenAddr  string
+	logFormat   string
+	corsOrigins []string
+}
+
+// Production has no default CORS origins, so the allowed web-app hosts have
+// to be listed in CORS_ORIGINS explicitly.
+var profiles = map[string]profile{
+	"dev":  {envFile: ".env", listenAddr: ":8080", logFormat: "text", corsOrigins: []string{"*"}},
+	"prod": {envFile: ".env.prod", listenAddr: ":8000", logFormat: "json"},
+}
+
+// Load builds the configuration from defaults, the profile's .env file, the
+// process environment and command-line flags, later sources winning. It
+// returns the arguments left after the flags.
+func Load(args []string) (Config, []string, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	profileName := fs.String("profile", "dev", "configuration profile: dev or prod")
+	envFile := fs.String("env-file", "", "env file to load instead of the profile default")
+	listen := fs.String("listen", "", "listen address, overrides LISTEN_ADDR")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	p, ok := profiles[*profileName]
+	if !ok {
+		return Config{}, nil, fmt.Errorf("config: unknown profile %q", *profileName)
+	}
+
+	if *envFile != "" {
+		if err := godotenv.Load(*envFile); err != nil {
+			return Config{}, nil, fmt.Errorf("config: loading %s: %w", *envFile, err)
+		}
+	} else if err := godotenv.Load(p.envFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, nil, fmt.Errorf("config: loading %s: %w", p.envFile, err)
+	}
+
+	e := &env{}
+	cfg := Config{
+		Profile:        *profileName,
+		ListenAddr:     e.string("LISTEN_ADDR", p.listenAddr),
+		BotToken:       e.string("BOT_TOKEN", ""),
+		AuthMaxAge:     e.duration("AUTH_MAX_AGE", 24*time.Hour),
+		IdempotencyTTL: e.duration("IDEMPOTENCY_TTL", 24*time.Hour),
+		CORS: CORS{
+			Origins:          e.list("CORS_ORIGINS", p.corsOrigins),
+			Methods:          e.list("CORS_METHODS", []string{"GET", "POST", "PUT", "DELETE"}),
+			Headers:          e.list("CORS_HEADERS", nil),
+			AllowCredentials: e.bool("CORS_ALLOW_CREDENTIALS", false),
+			MaxAge:           e.duration("CORS_MAX_AGE", 10*time.Minute),
+		},
+		LogLevel:  strings.ToLower(e.string("LOG_LEVEL", "info")),
+		LogFormat: strings.ToLower(e.string("LOG_FORMAT", p.logFormat)),
+		RateLimit: RateLimit{
+			TrustedProxies: e.prefixes("RATE_LIMIT_TRUSTED_PROXIES", nil),
+			IP: Limit{
+				Rate:  e.float64("RATE_LIMIT_IP_RATE", 20),
+				Burst: e.int("RATE_LIMIT_IP_BURST", 60),
+			},
+			Read: Limit{
+				Rate:  e.float64("RATE_LIMIT_READ_RATE", 10),
+				Burst: e.int("RATE_LIMIT_READ_BURST", 30),
+			},
+			Economy: Limit{
+				Rate:  e.float64("RATE_LIMIT_ECONOMY_RATE", 2),
+				Burst: e.int("RATE_LIMIT_ECONOMY_BURST", 5),
+			},
+		},
+		Mining: Mining{
+			Enabled:      e.bool("MINING_ENABLED", true),
+			PollInterval: e.duration("MINING_POLL_INTERVAL", time.Minute),
+		},
+		Trades: Trades{
+			SweepInterval: e.duration("TRADE_SWEEP_INTERVAL", time.Minute),
+		},
+		HTTP: HTTP{
+			ReadTimeout:       e.duration("HTTP_READ_TIMEOUT", 15*time.Second),
+			ReadHeaderTimeout: e.duration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+			WriteTimeout:      e.duration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+			IdleTimeout:       e.duration("HTTP_IDLE_TIMEOUT", 60*time.Second),
+			ShutdownTimeout:   e.duration("HTTP_SHUTDOWN_TIMEOUT", 20*time.Second),
+		},
+		Database: Database{
+			User:            e.string("DB_USER", ""),
+			Password:        e.string("DB_PASSWORD", ""),
+			Host:            e.string("DB_HOST", "localhost"),
+			Port:            e.int("DB_PORT", 3306),
+			Name:            e.string("DB_NAME", ""),
+			MaxOpenConns:    e.int("DB_MAX_OPEN_CONNS", 25),
+			MaxIdleConns:    e.int("DB_MAX_IDLE_CONNS", 25),
+			ConnMaxLifetime: e.duration("DB_CONN_MAX_LIFETIME", 5*time.Minute),
+			DialTimeout:     e.duration("DB_DIAL_TIMEOUT", 5*time.Second),
+			ReadTimeout:     e.duration("DB_READ_TIMEOUT", 30*time.Second),
+			WriteTimeout:    e.duration("DB_WRITE_TIMEOUT", 30*time.Second),
+		},
+		Game: Game{
+			SlotPrice:      e.int64("GAME_SLOT_PRICE", 2500000),
+			MaxSlots:       e.int("GAME_MAX_SLOTS", 9),
+			FreezeFuel:     e.int("GAME_FREEZE_FUEL", 50),
+			MaxFuel:        e.int("GAME_MAX_FUEL", 100),
+			IncomePerLevel: e.float32("GAME_INCOME_PER_LEVEL", 0.5),
+			MiningTick:     e.duration("GAME_MINING_TICK", time.Hour),
+			FuelPerTick:    e.int("GAME_FUEL_PER_TICK", 1),
+			FuelPerLevel:   e.int("GAME_FUEL_PER_LEVEL", 0),
+			MaxLevel:       e.int("GAME_MAX_LEVEL", 10),
+			UpgradeCosts: e.upgradeCosts("GAME_UPGRADE_COSTS", []UpgradeCost{
+				{"coin", 100}, {"coin", 250}, {"coin", 500}, {"coin", 1000}, {"coin", 2000},
+				{"balance", 5000000}, {"balance", 10000000}, {"gems", 50}, {"gems", 100},
+			}),
+			FuseCards:      e.int("GAME_FUSE_CARDS", 2),
+			FuseChance:     e.float64("GAME_FUSE_CHANCE", 0.5),
+			MarketFee:      e.float64("GAME_MARKET_FEE", 0.05),
+			TradeTTL:       e.duration("GAME_TRADE_TTL", 24*time.Hour),
+			CaseGemsMin:    e.int("GAME_CASE_GEMS_MIN", 1),
+			CaseGemsMax:    e.int("GAME_CASE_GEMS_MAX", 10),
+			CaseBalanceMin: e.int("GAME_CASE_BALANCE_MIN", 1000),
+			CaseBalanceMax: e.int("GAME_CASE_BALANCE_MAX", 10000),
+		},
+	}
+	if *listen != "" {
+		cfg.ListenAddr = *listen
+	}
+
+	if len(e.errs) > 0 {
+		return cfg, nil, errors.Join(e.errs...)
+	}
+	return cfg, fs.Args(), nil
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("config: "+format, args...))
+		}
+	}
+
+	check(c.ListenAddr != "", "LISTEN_ADDR must not be empty")
+	check(c.BotToken != "", "BOT_TOKEN is not set")
+	check(c.AuthMaxAge > 0, "AUTH_MAX_AGE must be positive")
+	check(c.IdempotencyTTL > 0, "IDEMPOTENCY_TTL must be positive")
+	check(len(c.CORS.Origins) > 0, "CORS_ORIGINS must not be empty")
+	for _, origin := range c.CORS.Origins {
+		check(validOrigin(origin), "CORS_ORIGINS: %q must be *, scheme://host or scheme://*.domain", origin)
+	}
+	check(!c.CORS.AllowCredentials || !slices.Contains(c.CORS.Origins, "*"),
+		"CORS_ALLOW_CREDENTIALS cannot be combined with a * origin")
+	check(len(c.CORS.Methods) > 0, "CORS_METHODS must not be empty")
+	check(c.CORS.MaxAge >= 0, "CORS_MAX_AGE must not be negative")
+	check(slices.Contains([]string{"debug", "info", "warn", "error"}, c.LogLevel),
+		"LOG_LEVEL %q must be debug, info, warn or error", c.LogLevel)
+	check(c.LogFormat == "text" || c.LogFormat == "json", "LOG_FORMAT %q must be text or json", c.LogFormat)
+
+	check(c.RateLimit.IP.Rate > 0, "RATE_LIMIT_IP_RATE must be positive")
+	check(c.RateLimit.IP.Burst > 0, "RATE_LIMIT_IP_BURST must be positive")
+	check(c.RateLimit.Read.Rate > 0, "RATE_LIMIT_READ_RATE must be positive")
+	check(c.RateLimit.Read.Burst > 0, "RATE_LIMIT_READ_BURST must be positive")
+	check(c.RateLimit.Economy.Rate > 0, "RATE_LIMIT_ECONOMY_RATE must be positive")
+	check(c.RateLimit.Economy.Burst > 0, "RATE_LIMIT_ECONOMY_BURST must be positive")
+
+	check(c.Mining.PollInterval > 0, "MINING_POLL_INTERVAL must be positive")
+	check(c.Trades.SweepInterval > 0, "TRADE_SWEEP_INTERVAL must be positive")
+
+	check(c.HTTP.ReadTimeout > 0, "HTTP_READ_TIMEOUT must be positive")
+	check(c.HTTP.ReadHeaderTimeout > 0, "HTTP_READ_HEADER_TIMEOUT must be positive")
+	check(c.HTTP.WriteTimeout > 0, "HTTP_WRITE_TIMEOUT must be positive")
+	check(c.HTTP.IdleTimeout > 0, "HTTP_IDLE_TIMEOUT must be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "HTTP_SHUTDOWN_TIMEOUT must be positive")
+
+	check(c.Database.User != "", "DB_USER is not set")
+	check(c.Database.Host != "", "DB_HOST is not set")
+	check(c.Database.Name != "", "DB_NAME is not set")
+	check(c.Database.Port > 0 && c.Database.Port < 65536, "DB_PORT %d is out of range", c.Database.Port)
+	check(c.Database.MaxOpenConns > 0, "DB_MAX_OPEN_CONNS must be positive")
+	check(c.Database.MaxIdleConns >= 0 && c.Database.MaxIdleConns <= c.Database.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS must be between 0 and DB_MAX_OPEN_CONNS")
+	check(c.Database.ConnMaxLifetime > 0, "DB_CONN_MAX_LIFETIME must be positive")
+
+	check(c.Game.SlotPrice >= 0, "GAME_SLOT_PRICE must not be negative")
+	check(c.Game.MaxSlots > 0, "GAME_MAX_SLOTS must be positive")
+	check(c.Game.MaxFuel > 0, "GAME_MAX_FUEL must be positive")
+	check(c.Game.FreezeFuel > 0, "GAME_FREEZE_FUEL must be positive")
+	check(c.Game.IncomePerLevel >= 0, "GAME_INCOME_PER_LEVEL must not be negative")
+	check(c.Game.MiningTick > 0, "GAME_MINING_TICK must be positive")
+	check(c.Game.FuelPerTick >= 0, "GAME_FUEL_PER_TICK must not be negative")
+	check(c.Game.FuelPerLevel >= 0, "GAME_FUEL_PER_LEVEL must not be negative")
+	check(c.Game.MaxLevel >= 1, "GAME_MAX_LEVEL must be at least 1")
+	check(len(c.Game.UpgradeCosts) >= c.Game.MaxLevel-1,
+		"GAME_UPGRADE_COSTS needs %d entries to reach GAME_MAX_LEVEL, has %d", c.Game.MaxLevel-1, len(c.Game.UpgradeCosts))
+	for _, cost := range c.Game.UpgradeCosts {
+		check(slices.Contains([]string{"coin", "balance", "gems"}, cost.Currency),
+			"GAME_UPGRADE_COSTS: currency %q must be coin, balance or gems", cost.Currency)
+		check(cost.Amount > 0, "GAME_UPGRADE_COSTS: amount %d must be positive", cost.Amount)
+	}
+	check(c.Game.FuseCards >= 2, "GAME_FUSE_CARDS must be at least 2")
+	check(c.Game.FuseChance >= 0 && c.Game.FuseChance <= 1, "GAME_FUSE_CHANCE must be between 0 and 1")
+	check(c.Game.MarketFee >= 0 && c.Game.MarketFee < 1, "GAME_MARKET_FEE must be at least 0 and below 1")
+	check(c.Game.TradeTTL > 0, "GAME_TRADE_TTL must be positive")
+	check(c.Game.CaseGemsMin >= 0 && c.Game.CaseGemsMin <= c.Game.CaseGemsMax,
+		"GAME_CASE_GEMS_MIN must be between 0 and GAME_CASE_GEMS_MAX")
+	check(c.Game.CaseBalanceMin >= 0 && c.Game.CaseBalanceMin <= c.Game.CaseBalanceMax,
+		"GAME_CASE_BALANCE_MIN must be between 0 and GAME_CASE_BALANCE_MAX")
+
+	return errors.Join(errs...)
+}
+
+// Print writes the effective configuration with secrets redacted.
+func (c Config) Print(w io.Writer) {
+	line := func(key string, value any) {
+		fmt.Fprintf(w, "%-28s %v\n", key, value)
+	}
+
+	line("PROFILE", c.Profile)
+	line("LISTEN_ADDR", c.ListenAddr)
+	line("BOT_TOKEN", redact(c.BotToken))
+	line("AUTH_MAX_AGE", c.AuthMaxAge)
+	line("IDEMPOTENCY_TTL", c.IdempotencyTTL)
+	line("CORS_ORIGINS", strings.Join(c.CORS.Origins, ","))
+	line("CORS_METHODS", strings.Join(c.CORS.Methods, ","))
+	line("CORS_HEADERS", strings.Join(c.CORS.Headers, ","))
+	line("CORS_ALLOW_CREDENTIALS", c.CORS.AllowCredentials)
+	line("CORS_MAX_AGE", c.CORS.MaxAge)
+	line("LOG_LEVEL", c.LogLevel)
+	line("LOG_FORMAT", c.LogFormat)
+	line("RATE_LIMIT_TRUSTED_PROXIES", joinPrefixes(c.RateLimit.TrustedProxies))
+	line("RATE_LIMIT_IP_RATE", c.RateLimit.IP.Rate)
+	line("RATE_LIMIT_IP_BURST", c.RateLimit.IP.Burst)
+	line("RATE_LIMIT_READ_RATE", c.RateLimit.Read.Rate)
+	line("RATE_LIMIT_READ_BURST", c.RateLimit.Read.Burst)
+	line("RATE_LIMIT_ECONOMY_RATE", c.RateLimit.Economy.Rate)
+	line("RATE_LIMIT_ECONOMY_BURST", c.RateLimit.Economy.Burst)
+	line("MINING_ENABLED", c.Mining.Enabled)
+	line("MINING_POLL_INTERVAL", c.Mining.PollInterval)
+	line("TRADE_SWEEP_INTERVAL", c.Trades.SweepInterval)
+	line("HTTP_READ_TIMEOUT", c.HTTP.ReadTimeout)
+	line("HTTP_READ_HEADER_TIMEOUT", c.HTTP.ReadHeaderTimeout)
+	line("HTTP_WRITE_TIMEOUT", c.HTTP.WriteTimeout)
+	line("HTTP_IDLE_TIMEOUT", c.HTTP.IdleTimeout)
+	line("HTTP_SHUTDOWN_TIMEOUT", c.HTTP.ShutdownTimeout)
+	line("DB_USER", c.Database.User)
+	line("DB_PASSWORD", redact(c.Database.Password))
+	line("DB_HOST", c.Database.Host)
+	line("DB_PORT", c.Database.Port)
+	line("DB_NAME", c.Database.Name)
+	line("DB_MAX_OPEN_CONNS", c.Database.MaxOpenConns)
+	line("DB_MAX_IDLE_CONNS", c.Database.MaxIdleConns)
+	line("DB_CONN_MAX_LIFETIME", c.Database.ConnMaxLifetime)
+	line("DB_DIAL_TIMEOUT", c.Database.DialTimeout)
+	line("DB_READ_TIMEOUT", c.Database.ReadTimeout)
+	line("DB_WRITE_TIMEOUT", c.Database.WriteTimeout)
+	line("GAME_SLOT_PRICE", c.Game.SlotPrice)
+	line("GAME_MAX_SLOTS", c.Game.MaxSlots)
+	line("GAME_FREEZE_FUEL", c.Game.FreezeFuel)
+	line("GAME_MAX_FUEL", c.Game.MaxFuel)
+	line("GAME_INCOME_PER_LEVEL", c.Game.IncomePerLevel)
+	line("GAME_MINING_TICK", c.Game.MiningTick)
+	line("GAME_FUEL_PER_TICK", c.Game.FuelPerTick)
+	line("GAME_FUEL_PER_LEVEL", c.Game.FuelPerLevel)
+	line("GAME_MAX_LEVEL", c.Game.MaxLevel)
+	costs := make([]string, len(c.Game.UpgradeCosts))
+	for i, cost := range c.Game.UpgradeCosts {
+		costs[i] = cost.String()
+	}
+	line("GAME_UPGRADE_COSTS", strings.Join(costs, ","))
+	line("GAME_FUSE_CARDS", c.Game.FuseCards)
+	line("GAME_FUSE_CHANCE", c.Game.FuseChance)
+	line("GAME_MARKET_FEE", c.Game.MarketFee)
+	line("GAME_TRADE_TTL", c.Game.TradeTTL)
+	line("GAME_CASE_GEMS_MIN", c.Game.CaseGemsMin)
+	line("GAME_CASE_GEMS_MAX", c.Game.CaseGemsMax)
+	line("GAME_CASE_BALANCE_MIN", c.Game.CaseBalanceMin)
+	line("GAME_CASE_BALANCE_MAX", c.Game.CaseBalanceMax)
+}
+
+// validOrigin accepts * on its own, an exact scheme://host[:port] origin or
+// a wildcard subdomain pattern such as https://*.example.com. The wildcard
+// must stand for whole labels so it cannot match unrelated domains.
+func validOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+
+	scheme, host, ok := strings.Cut(origin, "://")
+	if !ok || (scheme != "http" && scheme != "https") || host == "" || strings.ContainsAny(host, "/?#") {
+		return false
+	}
+	if rest, ok := strings.CutPrefix(host, "*."); ok {
+		return rest != "" && !strings.Contains(rest, "*")
+	}
+	return !strings.Contains(host, "*")
+}
+
+func joinPrefixes(prefixes []netip.Prefix) string {
+	items := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		items[i] = p.String()
+	}
+	return strings.Join(items, ",")
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return "(not set)"
+	}
+	return "********"
+}
+
+type env struct {
+	errs []error
+}
+
+func (e *env) string(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func (e *env) int(key string, def int) int {
+	v := e.string(key, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("config: %s: %q is not an integer", key, v))
+		return def
+	}
+	return n
+}
+
+func (e *env) int64(key string, def int64) int64 {
+	v := e.string(key, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("config: %s: %q is not an integer", key, v))
+		return def
+	}
+	return n
+}
+
+func (e *env) float32(key string, def float32) float32 {
+	v := e.string(key, "")
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 32)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("config: %s: %q is not a number", key, v))
+		return def
+	}
+	return float32(f)
+}
+
+func (e *env) float64(key string, def float64) float64 {
+	v := e.string(key, "")
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("config: %s: %q is not a number", key, v))
+		return def
+	}
+	return f
+}
+
+func (e *env) bool(key string, def bool) bool {
+	v := e.string(key, "")
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("config: %s: %q is not a boolean", key, v))
+		return def
+	}
+	return b
+}
+
+func (e *env) duration(key string, def time.Duration) time.Duration {
+	v := e.string(key, "")
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("config: %s: %q is not a duration", key, v))
+		return def
+	}
+	return d
+}
+
+func (e *env) list(key string, def []string) []string {
+	v := e.string(key, "")
+	if v == "" {
+		return def
+	}
+
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// prefixes reads a list of CIDR ranges. Bare addresses are taken as single
+// host ranges.
+func (e *env) prefixes(key string, def []netip.Prefix) []netip.Prefix {
+	items := e.list(key, nil)
+	if items == nil {
+		return def
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(items))
+	for _, item := range items {
+		if p, err := netip.ParsePrefix(item); err == nil {
+			prefixes = append(prefixes, p.Masked())
+		} else if addr, err := netip.ParseAddr(item); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else {
+			e.errs = append(e.errs, fmt.Errorf("config: %s: %q is not an address or CIDR range", key, item))
+		}
+	}
+	return prefixes
+}
+
+// upgradeCosts reads a comma-separated list of currency:amount pairs.
+func (e *env) upgradeCosts(key string, def []UpgradeCost) []UpgradeCost {
+	items := e.list(key, nil)
+	if items == nil {
+		return def
+	}
+
+	costs := make([]UpgradeCost, 0, len(items))
+	for _, item := range items {
+		currency, amount, ok := strings.Cut(item, ":")
+		n, err := strconv.ParseInt(strings.TrimSpace(amount), 10, 64)
+		if !ok || err != nil {
+			e.errs = append(e.errs, fmt.Errorf("config: %s: %q is not currency:amount", key, item))
+			continue
+		}
+		costs = append(costs, UpgradeCost{Currency: strings.TrimSpace(currency), Amount: n})
+	}
+	return costs
+}
@@ -0,0 +1,29 @@
+package database
+
+import "time"
+
+// CardFusion records one fusion attempt. CardId is the card that was kept,
+// Lvl the level of the inputs and ConsumedCardIds a comma-separated list of
+// the cards that were deleted.
+type CardFusion struct {
+	Id              int64     `db:"id"`
+	UserId          int       `db:"userId"`
+	CardId          int       `db:"cardId"`
+	Lvl             int       `db:"lvl"`
+	Success         bool      `db:"success"`
+	ConsumedCardIds string    `db:"consumedCardIds"`
+	CreatedAt       time.Time `db:"createdAt"`
+}
+
+func (r mysqlCards) DeleteCard(id int) error {
+	_, err := r.db.Exec("DELETE FROM cards WHERE id = ?", id)
+	return err
+}
+
+func (r mysqlCards) InsertCardFusion(f CardFusion) error {
+	_, err := r.db.Exec(`
+		INSERT INTO card_fusions (userId, cardId, lvl, success, consumedCardIds, createdAt)
+		VALUES (?, ?, ?, ?, ?, NOW())`,
+		f.UserId, f.CardId, f.Lvl, f.Success, f.ConsumedCardIds)
+	return err
+}
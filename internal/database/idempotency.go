@@ -0,0 +1,55 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type IdempotencyRecord struct {
+	ChatId      string    `db:"chatId"`
+	Key         string    `db:"idemKey"`
+	RequestHash string    `db:"requestHash"`
+	StatusCode  *int      `db:"statusCode"`
+	Headers     *string   `db:"headers"`
+	Body        []byte    `db:"body"`
+	CreatedAt   time.Time `db:"createdAt"`
+	ExpiresAt   time.Time `db:"expiresAt"`
+}
+
+func (r mysqlIdempotency) ReserveIdempotencyKey(chatId, key, requestHash string, ttl time.Duration) (bool, error) {
+	if _, err := r.db.Exec("DELETE FROM idempotency_keys WHERE expiresAt < NOW() LIMIT 100"); err != nil {
+		return false, err
+	}
+
+	_, err := r.db.Exec(`
+		INSERT INTO idempotency_keys (chatId, idemKey, requestHash, createdAt, expiresAt)
+		VALUES (?, ?, ?, NOW(), NOW() + INTERVAL ? SECOND)`,
+		chatId, key, requestHash, int64(ttl.Seconds()))
+	if isDuplicateKey(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r mysqlIdempotency) GetIdempotencyRecord(chatId, key string) (IdempotencyRecord, error) {
+	var record IdempotencyRecord
+	err := sqlx.Get(r.db, &record, "SELECT * FROM idempotency_keys WHERE chatId = ? AND idemKey = ?", chatId, key)
+	return record, err
+}
+
+func (r mysqlIdempotency) CompleteIdempotencyKey(chatId, key string, statusCode int, headers string, body []byte) error {
+	_, err := r.db.Exec(`
+		UPDATE idempotency_keys 
+		SET statusCode = ?, headers = ?, body = ? 
+		WHERE chatId = ? AND idemKey = ?`, statusCode, headers, body, chatId, key)
+	return err
+}
+
+func (r mysqlIdempotency) ReleaseIdempotencyKey(chatId, key string) error {
+	_, err := r.db.Exec("DELETE FROM idempotency_keys WHERE chatId = ? AND idemKey = ?", chatId, key)
+	return err
+}
@@ -0,0 +1,20 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func (r mysqlJobs) GetJobLastRunForUpdate(name string) (time.Time, error) {
+	var lastRun time.Time
+	err := sqlx.Get(r.db, &lastRun, "SELECT lastRunAt FROM job_runs WHERE name = ? FOR UPDATE", name)
+	return lastRun, err
+}
+
+func (r mysqlJobs) SetJobLastRun(name string, lastRun time.Time) error {
+	_, err := r.db.Exec(`
+		INSERT INTO job_runs (name, lastRunAt) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE lastRunAt = VALUES(lastRunAt)`, name, lastRun)
+	return err
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type LedgerEntry struct {
+	Id           int64     `db:"id"`
+	TransferId   string    `db:"transferId"`
+	Account      string    `db:"account"`
+	UserId       *int      `db:"userId"`
+	Currency     Currency  `db:"currency"`
+	Delta        int64     `db:"delta"`
+	BalanceAfter *int64    `db:"balanceAfter"`
+	Reason       string    `db:"reason"`
+	ReferenceId  *string   `db:"referenceId"`
+	CreatedAt    time.Time `db:"createdAt"`
+}
+
+type LedgerTotal struct {
+	UserId   int      `db:"userId"`
+	Currency Currency `db:"currency"`
+	Opening  int64    `db:"opening"`
+	Total    int64    `db:"total"`
+}
+
+func (r mysqlLedger) InsertLedgerEntry(entry LedgerEntry) error {
+	_, err := r.db.Exec(`
+		INSERT INTO ledger_entries 
+			(transferId, account, userId, currency, delta, balanceAfter, reason, referenceId, createdAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, NOW())`,
+		entry.TransferId, entry.Account, entry.UserId, entry.Currency, entry.Delta,
+		entry.BalanceAfter, entry.Reason, entry.ReferenceId)
+	return err
+}
+
+func (r mysqlLedger) GetUserLedger(userId int, before int64, limit int) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	var err error
+	if before > 0 {
+		err = sqlx.Select(r.db, &entries, `
+			SELECT * FROM ledger_entries 
+			WHERE userId = ? AND id < ? 
+			ORDER BY id DESC LIMIT ?`, userId, before, limit)
+	} else {
+		err = sqlx.Select(r.db, &entries, `
+			SELECT * FROM ledger_entries 
+			WHERE userId = ? 
+			ORDER BY id DESC LIMIT ?`, userId, limit)
+	}
+	return entries, err
+}
+
+func (r mysqlLedger) GetLedgerTotals() ([]LedgerTotal, error) {
+	var totals []LedgerTotal
+	err := sqlx.Select(r.db, &totals, `
+		SELECT 
+			l.userId, 
+			l.currency, 
+			SUM(l.delta) AS total,
+			(
+				SELECT f.balanceAfter - f.delta 
+				FROM ledger_entries f 
+				WHERE f.userId = l.userId AND f.currency = l.currency 
+				ORDER BY f.id LIMIT 1
+			) AS opening
+		FROM ledger_entries l
+		WHERE l.userId IS NOT NULL
+		GROUP BY l.userId, l.currency
+		ORDER BY l.userId, l.currency`)
+	return totals, err
+}
+
+func (r mysqlLedger) GetUnbalancedTransfers() ([]string, error) {
+	var ids []string
+	err := sqlx.Select(r.db, &ids, `
+		SELECT transferId FROM ledger_entries 
+		GROUP BY transferId 
+		HAVING SUM(delta) <> 0`)
+	return ids, err
+}
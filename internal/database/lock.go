@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MySQLLock is a named MySQL advisory lock used to elect a single leader
+// among replicas. GET_LOCK belongs to a connection, so the lock keeps one
+// connection out of the pool for as long as it is held and is released by
+// the server if that connection dies.
+type MySQLLock struct {
+	db   *sqlx.DB
+	name string
+	conn *sql.Conn
+}
+
+func NewMySQLLock(db *sqlx.DB, name string) *MySQLLock {
+	return &MySQLLock{db: db, name: name}
+}
+
+// Acquire reports whether this process holds the lock, taking it if it is
+// free. It does not wait for another holder to release it.
+func (l *MySQLLock) Acquire(ctx context.Context) (bool, error) {
+	if l.conn != nil {
+		var held sql.NullBool
+		err := l.conn.QueryRowContext(ctx, "SELECT IS_USED_LOCK(?) = CONNECTION_ID()", l.name).Scan(&held)
+		if err == nil && held.Valid && held.Bool {
+			return true, nil
+		}
+		l.conn.Close()
+		l.conn = nil
+		if err != nil {
+			return false, err
+		}
+	}
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var got sql.NullBool
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", l.name).Scan(&got); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if !got.Valid || !got.Bool {
+		conn.Close()
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+func (l *MySQLLock) Release(ctx context.Context) error {
+	if l.conn == nil {
+		return nil
+	}
+	_, err := l.conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", l.name)
+	l.conn.Close()
+	l.conn = nil
+	return err
+}
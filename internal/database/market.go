@@ -0,0 +1,169 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type ListingStatus string
+
+const (
+	ListingActive    ListingStatus = "active"
+	ListingSold      ListingStatus = "sold"
+	ListingCancelled ListingStatus = "cancelled"
+	// ListingDelisted is set when the card is installed, upgraded or fused
+	// while it is for sale.
+	ListingDelisted ListingStatus = "delisted"
+)
+
+type Listing struct {
+	Id        int64         `db:"id"`
+	SellerId  int           `db:"sellerId"`
+	BuyerId   *int          `db:"buyerId"`
+	CardId    int           `db:"cardId"`
+	Currency  Currency      `db:"currency"`
+	Price     int64         `db:"price"`
+	Status    ListingStatus `db:"status"`
+	CreatedAt time.Time     `db:"createdAt"`
+	UpdatedAt time.Time     `db:"updatedAt"`
+}
+
+// ListingWithCard is an active listing joined with the card for sale.
+type ListingWithCard struct {
+	Listing
+	Card Card `db:"card"`
+}
+
+type ListingSort string
+
+const (
+	SortNewest    ListingSort = "newest"
+	SortPriceAsc  ListingSort = "price_asc"
+	SortPriceDesc ListingSort = "price_desc"
+)
+
+// ListingQuery filters active listings. Zero bounds are ignored. After is
+// the last listing of the previous page; with a price sort its Price breaks
+// ties together with Id.
+type ListingQuery struct {
+	Currency Currency
+	MinLvl   int
+	MaxLvl   int
+	MinPrice int64
+	MaxPrice int64
+	Sort     ListingSort
+	After    *Listing
+	Limit    int
+}
+
+func (r mysqlMarket) CreateListing(l Listing) (Listing, error) {
+	res, err := r.db.Exec(`
+		INSERT INTO market_listings (sellerId, cardId, currency, price, status, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, ?, NOW(), NOW())`,
+		l.SellerId, l.CardId, l.Currency, l.Price, ListingActive)
+	if err != nil {
+		return Listing{}, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return Listing{}, err
+	}
+	err = sqlx.Get(r.db, &l, "SELECT * FROM market_listings WHERE id = ?", id)
+	return l, err
+}
+
+func (r mysqlMarket) GetListingForUpdate(id int64) (Listing, error) {
+	var l Listing
+	err := sqlx.Get(r.db, &l, "SELECT * FROM market_listings WHERE id = ? FOR UPDATE", id)
+	return l, err
+}
+
+func (r mysqlMarket) IsCardListed(cardId int) (bool, error) {
+	var n int
+	err := sqlx.Get(r.db, &n, `
+		SELECT COUNT(*) FROM market_listings
+		WHERE cardId = ? AND status = ?`, cardId, ListingActive)
+	return n > 0, err
+}
+
+func (r mysqlMarket) UpdateListingStatus(id int64, status ListingStatus, buyerId *int) error {
+	_, err := r.db.Exec(`
+		UPDATE market_listings
+		SET status = ?, buyerId = ?, updatedAt = NOW()
+		WHERE id = ?`, status, buyerId, id)
+	return err
+}
+
+func (r mysqlMarket) DelistCard(cardId int) error {
+	_, err := r.db.Exec(`
+		UPDATE market_listings
+		SET status = ?, updatedAt = NOW()
+		WHERE cardId = ? AND status = ?`, ListingDelisted, cardId, ListingActive)
+	return err
+}
+
+func (r mysqlMarket) SearchListings(q ListingQuery) ([]ListingWithCard, error) {
+	where := []string{"l.status = ?"}
+	args := []any{ListingActive}
+	add := func(cond string, a ...any) {
+		where = append(where, cond)
+		args = append(args, a...)
+	}
+
+	if q.Currency != "" {
+		add("l.currency = ?", q.Currency)
+	}
+	if q.MinLvl > 0 {
+		add("c.lvl >= ?", q.MinLvl)
+	}
+	if q.MaxLvl > 0 {
+		add("c.lvl <= ?", q.MaxLvl)
+	}
+	if q.MinPrice > 0 {
+		add("l.price >= ?", q.MinPrice)
+	}
+	if q.MaxPrice > 0 {
+		add("l.price <= ?", q.MaxPrice)
+	}
+
+	var order string
+	switch q.Sort {
+	case SortPriceAsc:
+		order = "l.price ASC, l.id ASC"
+		if q.After != nil {
+			add("(l.price > ? OR (l.price = ? AND l.id > ?))", q.After.Price, q.After.Price, q.After.Id)
+		}
+	case SortPriceDesc:
+		order = "l.price DESC, l.id DESC"
+		if q.After != nil {
+			add("(l.price < ? OR (l.price = ? AND l.id < ?))", q.After.Price, q.After.Price, q.After.Id)
+		}
+	default:
+		order = "l.id DESC"
+		if q.After != nil {
+			add("l.id < ?", q.After.Id)
+		}
+	}
+	args = append(args, q.Limit)
+
+	var listings []ListingWithCard
+	err := sqlx.Select(r.db, &listings, `
+		SELECT
+			l.*,
+			c.id AS "card.id",
+			c.userId AS "card.userId",
+			c.lvl AS "card.lvl",
+			c.fuel AS "card.fuel",
+			c.balance AS "card.balance",
+			c.createdAt AS "card.createdAt",
+			c.updatedAt AS "card.updatedAt"
+		FROM market_listings l
+		JOIN cards c ON c.id = l.cardId
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY `+order+`
+		LIMIT ?`, args...)
+	return listings, err
+}
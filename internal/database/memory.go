@@ -0,0 +1,899 @@
+package database
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory Store for tests. Transactions take an
+// exclusive lock on the whole store and work on a copy of the data that
+// replaces the original only when fn succeeds.
+type MemoryStore struct {
+	mu   sync.Mutex
+	data *memoryData
+}
+
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*memoryTx)(nil)
+)
+
+type memoryTx struct {
+	data *memoryData
+}
+
+type memoryData struct {
+	users    map[int]User
+	cards    map[int]Card
+	stands   map[int]CardStand
+	ledger   []LedgerEntry
+	jobs     map[string]time.Time
+	fusions  []CardFusion
+	listings map[int64]Listing
+	trades   map[int64]TradeOffer
+	idemKeys map[idempotencyKey]IdempotencyRecord
+
+	nextUserId    int
+	nextCardId    int
+	nextStandId   int
+	nextLedgerId  int64
+	nextFusionId  int64
+	nextListingId int64
+	nextTradeId   int64
+	nextItemId    int64
+}
+
+type memoryUsers struct{ run memoryRunner }
+type memoryCards struct{ run memoryRunner }
+type memoryStands struct{ run memoryRunner }
+type memoryLedger struct{ run memoryRunner }
+type memoryJobs struct{ run memoryRunner }
+type memoryMarket struct{ run memoryRunner }
+type memoryTrades struct{ run memoryRunner }
+type memoryIdempotency struct{ run memoryRunner }
+
+type idempotencyKey struct{ chatId, key string }
+
+type memoryRunner func(fn func(d *memoryData) error) error
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: &memoryData{
+		users:    map[int]User{},
+		cards:    map[int]Card{},
+		stands:   map[int]CardStand{},
+		jobs:     map[string]time.Time{},
+		listings: map[int64]Listing{},
+		trades:   map[int64]TradeOffer{},
+		idemKeys: map[idempotencyKey]IdempotencyRecord{},
+	}}
+}
+
+func (s *MemoryStore) run(fn func(d *memoryData) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.data)
+}
+
+func (s *MemoryStore) Users() UserRepository              { return memoryUsers{s.run} }
+func (s *MemoryStore) Cards() CardRepository              { return memoryCards{s.run} }
+func (s *MemoryStore) Stands() StandRepository            { return memoryStands{s.run} }
+func (s *MemoryStore) Ledger() LedgerRepository           { return memoryLedger{s.run} }
+func (s *MemoryStore) Jobs() JobRepository                { return memoryJobs{s.run} }
+func (s *MemoryStore) Market() MarketRepository           { return memoryMarket{s.run} }
+func (s *MemoryStore) Trades() TradeRepository            { return memoryTrades{s.run} }
+func (s *MemoryStore) Idempotency() IdempotencyRepository { return memoryIdempotency{s.run} }
+
+func (s *MemoryStore) WithTx(fn func(tx Store) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryTx{data: s.data.clone()}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.data = tx.data
+	return nil
+}
+
+func (s *MemoryStore) PutUser(user User) User {
+	s.run(func(d *memoryData) error {
+		if user.Id == 0 {
+			d.nextUserId++
+			user.Id = d.nextUserId
+		}
+		d.nextUserId = max(d.nextUserId, user.Id)
+		d.users[user.Id] = user
+		return nil
+	})
+	return user
+}
+
+func (s *MemoryStore) PutCard(card Card) Card {
+	s.run(func(d *memoryData) error {
+		if card.Id == 0 {
+			d.nextCardId++
+			card.Id = d.nextCardId
+		}
+		d.nextCardId = max(d.nextCardId, card.Id)
+		d.cards[card.Id] = card
+		return nil
+	})
+	return card
+}
+
+func (s *MemoryStore) PutStand(stand CardStand) CardStand {
+	s.run(func(d *memoryData) error {
+		if stand.Id == 0 {
+			d.nextStandId++
+			stand.Id = d.nextStandId
+		}
+		d.nextStandId = max(d.nextStandId, stand.Id)
+		stand.Card = nil
+		d.stands[stand.Id] = stand
+		return nil
+	})
+	return stand
+}
+
+// LedgerEntries returns every ledger entry, including those of system
+// accounts, oldest first.
+func (s *MemoryStore) LedgerEntries() []LedgerEntry {
+	var entries []LedgerEntry
+	s.run(func(d *memoryData) error {
+		entries = append(entries, d.ledger...)
+		return nil
+	})
+	return entries
+}
+
+// Fusions returns the recorded fusion attempts, oldest first.
+func (s *MemoryStore) Fusions() []CardFusion {
+	var fusions []CardFusion
+	s.run(func(d *memoryData) error {
+		fusions = append(fusions, d.fusions...)
+		return nil
+	})
+	return fusions
+}
+
+func (t *memoryTx) run(fn func(d *memoryData) error) error {
+	return fn(t.data)
+}
+
+func (t *memoryTx) Users() UserRepository              { return memoryUsers{t.run} }
+func (t *memoryTx) Cards() CardRepository              { return memoryCards{t.run} }
+func (t *memoryTx) Stands() StandRepository            { return memoryStands{t.run} }
+func (t *memoryTx) Ledger() LedgerRepository           { return memoryLedger{t.run} }
+func (t *memoryTx) Jobs() JobRepository                { return memoryJobs{t.run} }
+func (t *memoryTx) Market() MarketRepository           { return memoryMarket{t.run} }
+func (t *memoryTx) Trades() TradeRepository            { return memoryTrades{t.run} }
+func (t *memoryTx) Idempotency() IdempotencyRepository { return memoryIdempotency{t.run} }
+
+func (t *memoryTx) WithTx(fn func(tx Store) error) error {
+	return fn(t)
+}
+
+func (d *memoryData) clone() *memoryData {
+	c := *d
+	c.users = make(map[int]User, len(d.users))
+	for k, v := range d.users {
+		c.users[k] = v
+	}
+	c.cards = make(map[int]Card, len(d.cards))
+	for k, v := range d.cards {
+		c.cards[k] = v
+	}
+	c.stands = make(map[int]CardStand, len(d.stands))
+	for k, v := range d.stands {
+		c.stands[k] = v
+	}
+	c.ledger = append([]LedgerEntry(nil), d.ledger...)
+	c.fusions = append([]CardFusion(nil), d.fusions...)
+	c.listings = make(map[int64]Listing, len(d.listings))
+	for k, v := range d.listings {
+		c.listings[k] = v
+	}
+	// Items are never changed after a trade is created, so they can be
+	// shared with the copy.
+	c.trades = make(map[int64]TradeOffer, len(d.trades))
+	for k, v := range d.trades {
+		c.trades[k] = v
+	}
+	c.jobs = make(map[string]time.Time, len(d.jobs))
+	for k, v := range d.jobs {
+		c.jobs[k] = v
+	}
+	c.idemKeys = make(map[idempotencyKey]IdempotencyRecord, len(d.idemKeys))
+	for k, v := range d.idemKeys {
+		c.idemKeys[k] = v
+	}
+	return &c
+}
+
+func (d *memoryData) userByChatId(chatId string) (User, error) {
+	for _, u := range d.users {
+		if u.ChatId == chatId {
+			return u, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (d *memoryData) standByCardId(cardId int) (CardStand, bool) {
+	for _, s := range d.stands {
+		if s.CardId != nil && *s.CardId == cardId {
+			return s, true
+		}
+	}
+	return CardStand{}, false
+}
+
+func (r memoryUsers) GetUser(chatId string) (User, error) {
+	var user User
+	err := r.run(func(d *memoryData) error {
+		var err error
+		user, err = d.userByChatId(chatId)
+		return err
+	})
+	return user, err
+}
+
+func (r memoryUsers) GetUserForUpdate(chatId string) (User, error) {
+	return r.GetUser(chatId)
+}
+
+func (r memoryUsers) GetUserCurrency(userId int, currency Currency) (int64, error) {
+	if _, err := currency.column(); err != nil {
+		return 0, err
+	}
+
+	var amount int64
+	err := r.run(func(d *memoryData) error {
+		user, ok := d.users[userId]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		amount = userCurrency(user, currency)
+		return nil
+	})
+	return amount, err
+}
+
+func (r memoryUsers) AddUserCurrency(userId int, currency Currency, delta int64) error {
+	if _, err := currency.column(); err != nil {
+		return err
+	}
+	if delta == 0 {
+		return nil
+	}
+
+	return r.run(func(d *memoryData) error {
+		user, ok := d.users[userId]
+		if !ok {
+			if delta < 0 {
+				return &InsufficientFundsError{Currency: currency, Amount: -delta}
+			}
+			return nil
+		}
+
+		current := userCurrency(user, currency)
+		if delta < 0 && current < -delta {
+			return &InsufficientFundsError{Currency: currency, Amount: -delta}
+		}
+		setUserCurrency(&user, currency, current+delta)
+		user.UpdatedAt = time.Now()
+		d.users[userId] = user
+		return nil
+	})
+}
+
+func userCurrency(user User, currency Currency) int64 {
+	switch currency {
+	case CurrencyBalance:
+		return int64(user.Balance)
+	case CurrencyCoin:
+		return int64(user.Coin)
+	case CurrencyGems:
+		return int64(user.Gems)
+	case CurrencyChests:
+		return int64(user.Chests)
+	case CurrencyFreeze:
+		return int64(user.Freeze)
+	}
+	return 0
+}
+
+func setUserCurrency(user *User, currency Currency, v int64) {
+	switch currency {
+	case CurrencyBalance:
+		user.Balance = uint64(v)
+	case CurrencyCoin:
+		user.Coin = int(v)
+	case CurrencyGems:
+		user.Gems = int(v)
+	case CurrencyChests:
+		user.Chests = int(v)
+	case CurrencyFreeze:
+		user.Freeze = int(v)
+	}
+}
+
+func (r memoryCards) GetUserCards(userId int) ([]Card, error) {
+	var cards []Card
+	err := r.run(func(d *memoryData) error {
+		for _, c := range d.cards {
+			if c.UserId == userId {
+				cards = append(cards, c)
+			}
+		}
+		sort.Slice(cards, func(i, j int) bool { return cards[i].Id < cards[j].Id })
+		return nil
+	})
+	return cards, err
+}
+
+func (r memoryCards) GetCardById(id int) (Card, error) {
+	var card Card
+	err := r.run(func(d *memoryData) error {
+		c, ok := d.cards[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		card = c
+		return nil
+	})
+	return card, err
+}
+
+func (r memoryCards) GetCardByIdForUpdate(id int) (Card, error) {
+	return r.GetCardById(id)
+}
+
+func (r memoryCards) SubtractCardBalance(id int, amount float32) error {
+	return r.run(func(d *memoryData) error {
+		if c, ok := d.cards[id]; ok {
+			c.Balance -= amount
+			c.Updated = time.Now()
+			d.cards[id] = c
+		}
+		return nil
+	})
+}
+
+func (r memoryCards) UpdateCardFuel(id int, fuel int) error {
+	return r.run(func(d *memoryData) error {
+		if c, ok := d.cards[id]; ok {
+			c.Fuel = fuel
+			c.Updated = time.Now()
+			d.cards[id] = c
+		}
+		return nil
+	})
+}
+
+func (r memoryCards) GetInstalledCardsForUpdate() ([]Card, error) {
+	var cards []Card
+	err := r.run(func(d *memoryData) error {
+		for _, c := range d.cards {
+			if _, installed := d.standByCardId(c.Id); installed && c.Fuel > 0 {
+				cards = append(cards, c)
+			}
+		}
+		sort.Slice(cards, func(i, j int) bool { return cards[i].Id < cards[j].Id })
+		return nil
+	})
+	return cards, err
+}
+
+func (r memoryCards) UpdateCardMining(id int, balance float32, fuel int) error {
+	return r.run(func(d *memoryData) error {
+		if c, ok := d.cards[id]; ok {
+			c.Balance = balance
+			c.Fuel = fuel
+			c.Updated = time.Now()
+			d.cards[id] = c
+		}
+		return nil
+	})
+}
+
+func (r memoryCards) UpdateCardOwner(id int, userId int) error {
+	return r.run(func(d *memoryData) error {
+		if c, ok := d.cards[id]; ok {
+			c.UserId = userId
+			c.Updated = time.Now()
+			d.cards[id] = c
+		}
+		return nil
+	})
+}
+
+func (r memoryCards) UpdateCardLevel(id int, lvl int) error {
+	return r.run(func(d *memoryData) error {
+		if c, ok := d.cards[id]; ok {
+			c.Lvl = lvl
+			c.Updated = time.Now()
+			d.cards[id] = c
+		}
+		return nil
+	})
+}
+
+func (r memoryCards) DeleteCard(id int) error {
+	return r.run(func(d *memoryData) error {
+		delete(d.cards, id)
+		return nil
+	})
+}
+
+func (r memoryCards) InsertCardFusion(f CardFusion) error {
+	return r.run(func(d *memoryData) error {
+		d.nextFusionId++
+		f.Id = d.nextFusionId
+		f.CreatedAt = time.Now()
+		d.fusions = append(d.fusions, f)
+		return nil
+	})
+}
+
+func (r memoryStands) GetUserCardStands(userId int) ([]CardStand, error) {
+	var stands []CardStand
+	err := r.run(func(d *memoryData) error {
+		for _, s := range d.stands {
+			if s.UserId != userId {
+				continue
+			}
+			if s.CardId != nil {
+				if c, ok := d.cards[*s.CardId]; ok {
+					s.Card = &c
+				}
+			}
+			stands = append(stands, s)
+		}
+		sort.Slice(stands, func(i, j int) bool { return stands[i].Id < stands[j].Id })
+		return nil
+	})
+	return stands, err
+}
+
+func (r memoryStands) GetCardStandById(id int) (CardStand, error) {
+	var stand CardStand
+	err := r.run(func(d *memoryData) error {
+		s, ok := d.stands[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		stand = s
+		return nil
+	})
+	return stand, err
+}
+
+func (r memoryStands) GetCardStandByIdForUpdate(id int) (CardStand, error) {
+	return r.GetCardStandById(id)
+}
+
+func (r memoryStands) GetStandByCardId(cardId int) (CardStand, error) {
+	var stand CardStand
+	err := r.run(func(d *memoryData) error {
+		s, ok := d.standByCardId(cardId)
+		if !ok {
+			return sql.ErrNoRows
+		}
+		stand = s
+		return nil
+	})
+	return stand, err
+}
+
+func (r memoryStands) GetStandByCardIdForUpdate(cardId int) (CardStand, error) {
+	return r.GetStandByCardId(cardId)
+}
+
+func (r memoryStands) CreateCardStand(userId int) (CardStand, error) {
+	var stand CardStand
+	err := r.run(func(d *memoryData) error {
+		d.nextStandId++
+		now := time.Now()
+		stand = CardStand{Id: d.nextStandId, UserId: userId, CreatedAt: now, UpdatedAt: now}
+		d.stands[stand.Id] = stand
+		return nil
+	})
+	return stand, err
+}
+
+func (r memoryStands) InsertCardIntoStand(standId int, cardId int) error {
+	return r.run(func(d *memoryData) error {
+		if s, ok := d.stands[standId]; ok {
+			id := cardId
+			s.CardId = &id
+			s.UpdatedAt = time.Now()
+			d.stands[standId] = s
+		}
+		return nil
+	})
+}
+
+func (r memoryStands) RemoveCardFromStand(standId int) error {
+	return r.run(func(d *memoryData) error {
+		if s, ok := d.stands[standId]; ok {
+			s.CardId = nil
+			s.UpdatedAt = time.Now()
+			d.stands[standId] = s
+		}
+		return nil
+	})
+}
+
+func (r memoryStands) IsCardInstalledElsewhere(cardId int) (bool, error) {
+	var installed bool
+	err := r.run(func(d *memoryData) error {
+		_, installed = d.standByCardId(cardId)
+		return nil
+	})
+	return installed, err
+}
+
+func (r memoryLedger) InsertLedgerEntry(entry LedgerEntry) error {
+	return r.run(func(d *memoryData) error {
+		d.nextLedgerId++
+		entry.Id = d.nextLedgerId
+		entry.CreatedAt = time.Now()
+		d.ledger = append(d.ledger, entry)
+		return nil
+	})
+}
+
+func (r memoryLedger) GetUserLedger(userId int, before int64, limit int) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	err := r.run(func(d *memoryData) error {
+		for i := len(d.ledger) - 1; i >= 0 && len(entries) < limit; i-- {
+			e := d.ledger[i]
+			if e.UserId == nil || *e.UserId != userId || (before > 0 && e.Id >= before) {
+				continue
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (r memoryLedger) GetLedgerTotals() ([]LedgerTotal, error) {
+	var totals []LedgerTotal
+	err := r.run(func(d *memoryData) error {
+		type key struct {
+			userId   int
+			currency Currency
+		}
+		index := map[key]int{}
+		for _, e := range d.ledger {
+			if e.UserId == nil {
+				continue
+			}
+			k := key{*e.UserId, e.Currency}
+			i, ok := index[k]
+			if !ok {
+				var opening int64
+				if e.BalanceAfter != nil {
+					opening = *e.BalanceAfter - e.Delta
+				}
+				i = len(totals)
+				index[k] = i
+				totals = append(totals, LedgerTotal{UserId: k.userId, Currency: k.currency, Opening: opening})
+			}
+			totals[i].Total += e.Delta
+		}
+		sort.Slice(totals, func(i, j int) bool {
+			if totals[i].UserId != totals[j].UserId {
+				return totals[i].UserId < totals[j].UserId
+			}
+			return totals[i].Currency < totals[j].Currency
+		})
+		return nil
+	})
+	return totals, err
+}
+
+func (r memoryLedger) GetUnbalancedTransfers() ([]string, error) {
+	var ids []string
+	err := r.run(func(d *memoryData) error {
+		sums := map[string]int64{}
+		var order []string
+		for _, e := range d.ledger {
+			if _, ok := sums[e.TransferId]; !ok {
+				order = append(order, e.TransferId)
+			}
+			sums[e.TransferId] += e.Delta
+		}
+		for _, id := range order {
+			if sums[id] != 0 {
+				ids = append(ids, id)
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+func (r memoryJobs) GetJobLastRunForUpdate(name string) (time.Time, error) {
+	var lastRun time.Time
+	err := r.run(func(d *memoryData) error {
+		t, ok := d.jobs[name]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		lastRun = t
+		return nil
+	})
+	return lastRun, err
+}
+
+func (r memoryJobs) SetJobLastRun(name string, lastRun time.Time) error {
+	return r.run(func(d *memoryData) error {
+		d.jobs[name] = lastRun
+		return nil
+	})
+}
+
+func (r memoryMarket) CreateListing(l Listing) (Listing, error) {
+	err := r.run(func(d *memoryData) error {
+		d.nextListingId++
+		l.Id = d.nextListingId
+		l.BuyerId = nil
+		l.Status = ListingActive
+		l.CreatedAt = time.Now()
+		l.UpdatedAt = l.CreatedAt
+		d.listings[l.Id] = l
+		return nil
+	})
+	return l, err
+}
+
+func (r memoryMarket) GetListingForUpdate(id int64) (Listing, error) {
+	var listing Listing
+	err := r.run(func(d *memoryData) error {
+		l, ok := d.listings[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		listing = l
+		return nil
+	})
+	return listing, err
+}
+
+func (r memoryMarket) IsCardListed(cardId int) (bool, error) {
+	var listed bool
+	err := r.run(func(d *memoryData) error {
+		for _, l := range d.listings {
+			if l.CardId == cardId && l.Status == ListingActive {
+				listed = true
+			}
+		}
+		return nil
+	})
+	return listed, err
+}
+
+func (r memoryMarket) UpdateListingStatus(id int64, status ListingStatus, buyerId *int) error {
+	return r.run(func(d *memoryData) error {
+		if l, ok := d.listings[id]; ok {
+			l.Status = status
+			l.BuyerId = buyerId
+			l.UpdatedAt = time.Now()
+			d.listings[id] = l
+		}
+		return nil
+	})
+}
+
+func (r memoryMarket) DelistCard(cardId int) error {
+	return r.run(func(d *memoryData) error {
+		for id, l := range d.listings {
+			if l.CardId == cardId && l.Status == ListingActive {
+				l.Status = ListingDelisted
+				l.UpdatedAt = time.Now()
+				d.listings[id] = l
+			}
+		}
+		return nil
+	})
+}
+
+func (r memoryMarket) SearchListings(q ListingQuery) ([]ListingWithCard, error) {
+	var listings []ListingWithCard
+	err := r.run(func(d *memoryData) error {
+		for _, l := range d.listings {
+			card, ok := d.cards[l.CardId]
+			if !ok || l.Status != ListingActive ||
+				(q.Currency != "" && l.Currency != q.Currency) ||
+				(q.MinLvl > 0 && card.Lvl < q.MinLvl) ||
+				(q.MaxLvl > 0 && card.Lvl > q.MaxLvl) ||
+				(q.MinPrice > 0 && l.Price < q.MinPrice) ||
+				(q.MaxPrice > 0 && l.Price > q.MaxPrice) {
+				continue
+			}
+			listings = append(listings, ListingWithCard{Listing: l, Card: card})
+		}
+
+		less := func(a, b Listing) bool { return a.Id > b.Id }
+		switch q.Sort {
+		case SortPriceAsc:
+			less = func(a, b Listing) bool { return a.Price < b.Price || (a.Price == b.Price && a.Id < b.Id) }
+		case SortPriceDesc:
+			less = func(a, b Listing) bool { return a.Price > b.Price || (a.Price == b.Price && a.Id > b.Id) }
+		}
+		sort.Slice(listings, func(i, j int) bool { return less(listings[i].Listing, listings[j].Listing) })
+
+		if q.After != nil {
+			i := sort.Search(len(listings), func(i int) bool { return less(*q.After, listings[i].Listing) })
+			listings = listings[i:]
+		}
+		if len(listings) > q.Limit {
+			listings = listings[:q.Limit]
+		}
+		return nil
+	})
+	return listings, err
+}
+
+func (r memoryTrades) CreateTrade(t TradeOffer) (TradeOffer, error) {
+	err := r.run(func(d *memoryData) error {
+		d.nextTradeId++
+		t.Id = d.nextTradeId
+		t.Status = TradePending
+		t.CreatedAt = time.Now()
+		t.UpdatedAt = t.CreatedAt
+
+		items := make([]TradeItem, len(t.Items))
+		for i, item := range t.Items {
+			d.nextItemId++
+			item.Id = d.nextItemId
+			item.TradeId = t.Id
+			items[i] = item
+		}
+		t.Items = items
+
+		d.trades[t.Id] = t
+		return nil
+	})
+	return t, err
+}
+
+func (r memoryTrades) GetTradeForUpdate(id int64) (TradeOffer, error) {
+	var trade TradeOffer
+	err := r.run(func(d *memoryData) error {
+		t, ok := d.trades[id]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		trade = t
+		return nil
+	})
+	return trade, err
+}
+
+func (r memoryTrades) GetUserPendingTrades(userId int) ([]TradeOffer, error) {
+	var trades []TradeOffer
+	err := r.run(func(d *memoryData) error {
+		for _, t := range d.trades {
+			if t.Status == TradePending && (t.FromUserId == userId || t.ToUserId == userId) {
+				trades = append(trades, t)
+			}
+		}
+		sort.Slice(trades, func(i, j int) bool { return trades[i].Id > trades[j].Id })
+		return nil
+	})
+	return trades, err
+}
+
+func (r memoryTrades) GetExpiredTradeIds(now time.Time, limit int) ([]int64, error) {
+	var ids []int64
+	err := r.run(func(d *memoryData) error {
+		for _, t := range d.trades {
+			if t.Status == TradePending && !t.ExpiresAt.After(now) {
+				ids = append(ids, t.Id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		if len(ids) > limit {
+			ids = ids[:limit]
+		}
+		return nil
+	})
+	return ids, err
+}
+
+func (r memoryTrades) IsCardInTrade(cardId int) (bool, error) {
+	var held bool
+	err := r.run(func(d *memoryData) error {
+		for _, t := range d.trades {
+			if t.Status != TradePending {
+				continue
+			}
+			for _, item := range t.Items {
+				if item.Side == TradeOffered && item.CardId != nil && *item.CardId == cardId {
+					held = true
+				}
+			}
+		}
+		return nil
+	})
+	return held, err
+}
+
+func (r memoryTrades) UpdateTradeStatus(id int64, status TradeStatus) error {
+	return r.run(func(d *memoryData) error {
+		if t, ok := d.trades[id]; ok {
+			t.Status = status
+			t.UpdatedAt = time.Now()
+			d.trades[id] = t
+		}
+		return nil
+	})
+}
+
+func (r memoryIdempotency) ReserveIdempotencyKey(chatId, key, requestHash string, ttl time.Duration) (bool, error) {
+	var reserved bool
+	err := r.run(func(d *memoryData) error {
+		now := time.Now()
+		for k, record := range d.idemKeys {
+			if record.ExpiresAt.Before(now) {
+				delete(d.idemKeys, k)
+			}
+		}
+
+		k := idempotencyKey{chatId, key}
+		if _, ok := d.idemKeys[k]; ok {
+			return nil
+		}
+		d.idemKeys[k] = IdempotencyRecord{
+			ChatId:      chatId,
+			Key:         key,
+			RequestHash: requestHash,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(ttl),
+		}
+		reserved = true
+		return nil
+	})
+	return reserved, err
+}
+
+func (r memoryIdempotency) GetIdempotencyRecord(chatId, key string) (IdempotencyRecord, error) {
+	var record IdempotencyRecord
+	err := r.run(func(d *memoryData) error {
+		var ok bool
+		record, ok = d.idemKeys[idempotencyKey{chatId, key}]
+		if !ok {
+			return sql.ErrNoRows
+		}
+		return nil
+	})
+	return record, err
+}
+
+func (r memoryIdempotency) CompleteIdempotencyKey(chatId, key string, statusCode int, headers string, body []byte) error {
+	return r.run(func(d *memoryData) error {
+		k := idempotencyKey{chatId, key}
+		record, ok := d.idemKeys[k]
+		if !ok {
+			return nil
+		}
+		record.StatusCode = &statusCode
+		record.Headers = &headers
+		record.Body = append([]byte(nil), body...)
+		d.idemKeys[k] = record
+		return nil
+	})
+}
+
+func (r memoryIdempotency) ReleaseIdempotencyKey(chatId, key string) error {
+	return r.run(func(d *memoryData) error {
+		delete(d.idemKeys, idempotencyKey{chatId, key})
+		return nil
+	})
+}
@@ -42,9 +42,9 @@ type CardStandJoined struct {
 	Card_UpdatedAt *time.Time `db:"card.updatedAt"`
 }
 
-func GetUserCardStands(db *sqlx.DB, userId int) ([]CardStand, error) {
+func (r mysqlStands) GetUserCardStands(userId int) ([]CardStand, error) {
 	var rows []CardStandJoined
-	err := db.Select(&rows, `
+	err := sqlx.Select(r.db, &rows, `
 		SELECT 
 			cs.id, 
 			cs.userId, 
@@ -93,74 +93,133 @@ func GetUserCardStands(db *sqlx.DB, userId int) ([]CardStand, error) {
 	return stands, nil
 }
 
-func GetUserCards(db *sqlx.DB, userId int) ([]Card, error) {
+func (r mysqlCards) GetUserCards(userId int) ([]Card, error) {
 	var cards []Card
-	err := db.Select(&cards, "SELECT * FROM cards WHERE userId = ?", userId)
+	err := sqlx.Select(r.db, &cards, "SELECT * FROM cards WHERE userId = ?", userId)
 	return cards, err
 }
 
-func GetCardById(db *sqlx.DB, gpuId int) (Card, error) {
+func (r mysqlCards) GetCardById(gpuId int) (Card, error) {
 	var card Card
-	err := db.Get(&card, "SELECT * FROM cards WHERE id = ?", gpuId)
+	err := sqlx.Get(r.db, &card, "SELECT * FROM cards WHERE id = ?", gpuId)
 	return card, err
 }
 
-func GetCardStandById(db *sqlx.DB, standId int) (CardStand, error) {
+func (r mysqlCards) GetCardByIdForUpdate(gpuId int) (Card, error) {
+	var card Card
+	err := sqlx.Get(r.db, &card, "SELECT * FROM cards WHERE id = ? FOR UPDATE", gpuId)
+	return card, err
+}
+
+func (r mysqlStands) GetCardStandById(standId int) (CardStand, error) {
+	var stand CardStand
+	err := sqlx.Get(r.db, &stand, "SELECT * FROM cardStands WHERE id = ?", standId)
+	return stand, err
+}
+
+func (r mysqlStands) GetCardStandByIdForUpdate(standId int) (CardStand, error) {
 	var stand CardStand
-	err := db.Get(&stand, "SELECT * FROM cardStands WHERE id = ?", standId)
+	err := sqlx.Get(r.db, &stand, "SELECT * FROM cardStands WHERE id = ? FOR UPDATE", standId)
 	return stand, err
 }
 
-func GetStandByCardId(db *sqlx.DB, cardId int) (CardStand, error) {
+func (r mysqlStands) GetStandByCardId(cardId int) (CardStand, error) {
 	var stand CardStand
-	err := db.Get(&stand, "SELECT * FROM cardStands WHERE cardId = ?", cardId)
+	err := sqlx.Get(r.db, &stand, "SELECT * FROM cardStands WHERE cardId = ?", cardId)
 	return stand, err
 }
 
-func ResetCardBalance(db *sqlx.DB, id int) error {
-	_, err := db.Exec("UPDATE cards SET balance = 0, updatedAt = NOW() WHERE id = ?", id)
+func (r mysqlStands) GetStandByCardIdForUpdate(cardId int) (CardStand, error) {
+	var stand CardStand
+	err := sqlx.Get(r.db, &stand, "SELECT * FROM cardStands WHERE cardId = ? FOR UPDATE", cardId)
+	return stand, err
+}
+
+func (r mysqlCards) SubtractCardBalance(id int, amount float32) error {
+	_, err := r.db.Exec("UPDATE cards SET balance = balance - ?, updatedAt = NOW() WHERE id = ?", amount, id)
 	return err
 }
 
-func CreateCardStand(db *sqlx.DB, userId int) (CardStand, error) {
+func (r mysqlStands) CreateCardStand(userId int) (CardStand, error) {
 	var stand CardStand
-	_, err := db.Exec(`
+	res, err := r.db.Exec(`
 		INSERT INTO cardStands (userId, cardId, createdAt, updatedAt)
 		VALUES (?, NULL, NOW(), NOW())`, userId)
 	if err != nil {
 		return stand, err
 	}
-	err = db.Get(&stand, "SELECT * FROM cardStands WHERE userId = ? ORDER BY id DESC LIMIT 1", userId)
+	id, err := res.LastInsertId()
+	if err != nil {
+		return stand, err
+	}
+	err = sqlx.Get(r.db, &stand, "SELECT * FROM cardStands WHERE id = ?", id)
 	return stand, err
 }
 
-func InsertCardIntoStand(db *sqlx.DB, standId int, cardId int) error {
-	_, err := db.Exec(`
+func (r mysqlStands) InsertCardIntoStand(standId int, cardId int) error {
+	_, err := r.db.Exec(`
 		UPDATE cardStands 
 		SET cardId = ?, updatedAt = NOW() 
 		WHERE id = ?`, cardId, standId)
 	return err
 }
 
-func UpdateCardFuel(db *sqlx.DB, cardId int, fuel int) error {
-	_, err := db.Exec(`
+func (r mysqlCards) UpdateCardFuel(cardId int, fuel int) error {
+	_, err := r.db.Exec(`
 		UPDATE cards 
 		SET fuel = ?, updatedAt = NOW() 
 		WHERE id = ?`, fuel, cardId)
 	return err
 }
 
-func RemoveCardFromStand(db *sqlx.DB, standId int) error {
-	_, err := db.Exec(`
+// GetInstalledCardsForUpdate locks the cards that sit in a stand and still
+// have fuel, i.e. the ones that are mining.
+func (r mysqlCards) GetInstalledCardsForUpdate() ([]Card, error) {
+	var cards []Card
+	err := sqlx.Select(r.db, &cards, `
+		SELECT c.* FROM cards c
+		JOIN cardStands s ON s.cardId = c.id
+		WHERE c.fuel > 0
+		ORDER BY c.id
+		FOR UPDATE`)
+	return cards, err
+}
+
+func (r mysqlCards) UpdateCardMining(id int, balance float32, fuel int) error {
+	_, err := r.db.Exec(`
+		UPDATE cards
+		SET balance = ?, fuel = ?, updatedAt = NOW()
+		WHERE id = ?`, balance, fuel, id)
+	return err
+}
+
+func (r mysqlCards) UpdateCardOwner(id int, userId int) error {
+	_, err := r.db.Exec(`
+		UPDATE cards
+		SET userId = ?, updatedAt = NOW()
+		WHERE id = ?`, userId, id)
+	return err
+}
+
+func (r mysqlCards) UpdateCardLevel(id int, lvl int) error {
+	_, err := r.db.Exec(`
+		UPDATE cards
+		SET lvl = ?, updatedAt = NOW()
+		WHERE id = ?`, lvl, id)
+	return err
+}
+
+func (r mysqlStands) RemoveCardFromStand(standId int) error {
+	_, err := r.db.Exec(`
 		UPDATE cardStands 
 		SET cardId = NULL, updatedAt = NOW() 
 		WHERE id = ?`, standId)
 	return err
 }
 
-func IsCardInstalledElsewhere(db *sqlx.DB, cardId int) (bool, error) {
+func (r mysqlStands) IsCardInstalledElsewhere(cardId int) (bool, error) {
 	var existingStandId int
-	err := db.Get(&existingStandId, "SELECT id FROM cardStands WHERE cardId = ?", cardId)
+	err := sqlx.Get(r.db, &existingStandId, "SELECT id FROM cardStands WHERE cardId = ?", cardId)
 	if err == sql.ErrNoRows {
 		return false, nil
 	}
@@ -0,0 +1,65 @@
+package database
+
+import "github.com/jmoiron/sqlx"
+
+type MySQLStore struct {
+	db  *sqlx.DB
+	ext sqlx.Ext
+}
+
+var _ Store = (*MySQLStore)(nil)
+
+type mysqlUsers struct{ db sqlx.Ext }
+type mysqlCards struct{ db sqlx.Ext }
+type mysqlStands struct{ db sqlx.Ext }
+type mysqlLedger struct{ db sqlx.Ext }
+type mysqlJobs struct{ db sqlx.Ext }
+type mysqlMarket struct{ db sqlx.Ext }
+type mysqlTrades struct{ db sqlx.Ext }
+type mysqlIdempotency struct{ db sqlx.Ext }
+
+func NewMySQLStore(db *sqlx.DB) *MySQLStore {
+	return &MySQLStore{db: db, ext: db}
+}
+
+func (s *MySQLStore) Users() UserRepository {
+	return mysqlUsers{s.ext}
+}
+
+func (s *MySQLStore) Cards() CardRepository {
+	return mysqlCards{s.ext}
+}
+
+func (s *MySQLStore) Stands() StandRepository {
+	return mysqlStands{s.ext}
+}
+
+func (s *MySQLStore) Ledger() LedgerRepository {
+	return mysqlLedger{s.ext}
+}
+
+func (s *MySQLStore) Jobs() JobRepository {
+	return mysqlJobs{s.ext}
+}
+
+func (s *MySQLStore) Market() MarketRepository {
+	return mysqlMarket{s.ext}
+}
+
+func (s *MySQLStore) Trades() TradeRepository {
+	return mysqlTrades{s.ext}
+}
+
+func (s *MySQLStore) Idempotency() IdempotencyRepository {
+	return mysqlIdempotency{s.ext}
+}
+
+func (s *MySQLStore) WithTx(fn func(tx Store) error) error {
+	if _, ok := s.ext.(*sqlx.Tx); ok {
+		return fn(s)
+	}
+
+	return WithTx(s.db, func(tx *sqlx.Tx) error {
+		return fn(&MySQLStore{db: s.db, ext: tx})
+	})
+}
@@ -0,0 +1,97 @@
+package database
+
+import "time"
+
+type UserRepository interface {
+	GetUser(chatId string) (User, error)
+	GetUserForUpdate(chatId string) (User, error)
+	GetUserCurrency(userId int, currency Currency) (int64, error)
+	AddUserCurrency(userId int, currency Currency, delta int64) error
+}
+
+type CardRepository interface {
+	GetUserCards(userId int) ([]Card, error)
+	GetCardById(id int) (Card, error)
+	GetCardByIdForUpdate(id int) (Card, error)
+	SubtractCardBalance(id int, amount float32) error
+	UpdateCardFuel(id int, fuel int) error
+	GetInstalledCardsForUpdate() ([]Card, error)
+	UpdateCardMining(id int, balance float32, fuel int) error
+	UpdateCardLevel(id int, lvl int) error
+	UpdateCardOwner(id int, userId int) error
+	DeleteCard(id int) error
+	InsertCardFusion(f CardFusion) error
+}
+
+type StandRepository interface {
+	GetUserCardStands(userId int) ([]CardStand, error)
+	GetCardStandById(id int) (CardStand, error)
+	GetCardStandByIdForUpdate(id int) (CardStand, error)
+	GetStandByCardId(cardId int) (CardStand, error)
+	GetStandByCardIdForUpdate(cardId int) (CardStand, error)
+	CreateCardStand(userId int) (CardStand, error)
+	InsertCardIntoStand(standId int, cardId int) error
+	RemoveCardFromStand(standId int) error
+	IsCardInstalledElsewhere(cardId int) (bool, error)
+}
+
+type LedgerRepository interface {
+	InsertLedgerEntry(entry LedgerEntry) error
+	GetUserLedger(userId int, before int64, limit int) ([]LedgerEntry, error)
+	GetLedgerTotals() ([]LedgerTotal, error)
+	GetUnbalancedTransfers() ([]string, error)
+}
+
+// MarketRepository stores cards listed for sale between players. A card has
+// at most one active listing.
+type MarketRepository interface {
+	CreateListing(l Listing) (Listing, error)
+	GetListingForUpdate(id int64) (Listing, error)
+	IsCardListed(cardId int) (bool, error)
+	UpdateListingStatus(id int64, status ListingStatus, buyerId *int) error
+	DelistCard(cardId int) error
+	SearchListings(q ListingQuery) ([]ListingWithCard, error)
+}
+
+// TradeRepository stores trade offers between two users. Cards on the
+// offered side of a pending trade are held in escrow.
+type TradeRepository interface {
+	CreateTrade(t TradeOffer) (TradeOffer, error)
+	GetTradeForUpdate(id int64) (TradeOffer, error)
+	GetUserPendingTrades(userId int) ([]TradeOffer, error)
+	GetExpiredTradeIds(now time.Time, limit int) ([]int64, error)
+	IsCardInTrade(cardId int) (bool, error)
+	UpdateTradeStatus(id int64, status TradeStatus) error
+}
+
+// JobRepository records when background jobs last ran, so a job shared by
+// several replicas can tell what is still due.
+type JobRepository interface {
+	GetJobLastRunForUpdate(name string) (time.Time, error)
+	SetJobLastRun(name string, lastRun time.Time) error
+}
+
+// IdempotencyRepository keeps the first response to a request sent with an
+// Idempotency-Key, per user, until the key expires.
+type IdempotencyRepository interface {
+	ReserveIdempotencyKey(chatId, key, requestHash string, ttl time.Duration) (bool, error)
+	GetIdempotencyRecord(chatId, key string) (IdempotencyRecord, error)
+	CompleteIdempotencyKey(chatId, key string, statusCode int, headers string, body []byte) error
+	ReleaseIdempotencyKey(chatId, key string) error
+}
+
+// Store hands out repositories. Repositories obtained inside WithTx share one
+// transaction: the "ForUpdate" reads lock rows until it ends, and everything
+// is rolled back if fn returns an error. Calling WithTx on a transactional
+// Store runs fn in the same transaction.
+type Store interface {
+	Users() UserRepository
+	Cards() CardRepository
+	Stands() StandRepository
+	Ledger() LedgerRepository
+	Jobs() JobRepository
+	Market() MarketRepository
+	Trades() TradeRepository
+	Idempotency() IdempotencyRepository
+	WithTx(fn func(tx Store) error) error
+}
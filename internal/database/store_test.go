@@ -0,0 +1,392 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"example.com/myapp/internal/migrations"
+	"github.com/jmoiron/sqlx"
+)
+
+// testDSNEnv names a MySQL DSN, with parseTime=true, to run the store suite
+// against. The database is migrated and its tables are emptied before every
+// test.
+const testDSNEnv = "TEST_MYSQL_DSN"
+
+// storeFixture is a Store together with the seeding it cannot do itself.
+type storeFixture struct {
+	Store
+	putUser func(t *testing.T, chatId string, coin int) User
+	putCard func(t *testing.T, userId, lvl int) Card
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) storeFixture {
+		store := NewMemoryStore()
+		return storeFixture{
+			Store: store,
+			putUser: func(t *testing.T, chatId string, coin int) User {
+				return store.PutUser(User{ChatId: chatId, Coin: coin})
+			},
+			putCard: func(t *testing.T, userId, lvl int) Card {
+				return store.PutCard(Card{UserId: userId, Lvl: lvl})
+			},
+		}
+	})
+}
+
+func TestMySQLStore(t *testing.T) {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skip(testDSNEnv + " is not set")
+	}
+
+	db, err := sqlx.Connect("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrations.Up(db, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+
+	testStore(t, func(t *testing.T) storeFixture {
+		for _, table := range []string{
+			"trade_offer_items", "trade_offers", "market_listings", "card_fusions",
+			"ledger_entries", "idempotency_keys", "cardStands", "cards", "users",
+		} {
+			if _, err := db.Exec("DELETE FROM " + table); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return storeFixture{
+			Store: NewMySQLStore(db),
+			putUser: func(t *testing.T, chatId string, coin int) User {
+				_, err := db.Exec(`
+					INSERT INTO users (chatId, coin, createdAt, updatedAt)
+					VALUES (?, ?, NOW(), NOW())`, chatId, coin)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var user User
+				if err := db.Get(&user, "SELECT * FROM users WHERE chatId = ?", chatId); err != nil {
+					t.Fatal(err)
+				}
+				return user
+			},
+			putCard: func(t *testing.T, userId, lvl int) Card {
+				res, err := db.Exec(`
+					INSERT INTO cards (userId, lvl, createdAt, updatedAt)
+					VALUES (?, ?, NOW(), NOW())`, userId, lvl)
+				if err != nil {
+					t.Fatal(err)
+				}
+				id, err := res.LastInsertId()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var card Card
+				if err := db.Get(&card, "SELECT * FROM cards WHERE id = ?", id); err != nil {
+					t.Fatal(err)
+				}
+				return card
+			},
+		}
+	})
+}
+
+// testStore checks the behaviour every Store must share.
+func testStore(t *testing.T, newFixture func(t *testing.T) storeFixture) {
+	t.Run("GuardedDebit", func(t *testing.T) {
+		f := newFixture(t)
+		user := f.putUser(t, "1", 10)
+
+		err := f.Users().AddUserCurrency(user.Id, CurrencyCoin, -11)
+		var insufficient *InsufficientFundsError
+		if !errors.As(err, &insufficient) {
+			t.Fatalf("overdraft: got %v, want InsufficientFundsError", err)
+		}
+		if insufficient.Currency != CurrencyCoin || insufficient.Amount != 11 {
+			t.Errorf("overdraft: got %+v", insufficient)
+		}
+		if got := coin(t, f, user.Id); got != 10 {
+			t.Errorf("coin after overdraft = %d, want 10", got)
+		}
+
+		if err := f.Users().AddUserCurrency(user.Id, CurrencyCoin, -10); err != nil {
+			t.Fatalf("debit to zero: %v", err)
+		}
+		if got := coin(t, f, user.Id); got != 0 {
+			t.Errorf("coin after debit = %d, want 0", got)
+		}
+	})
+
+	t.Run("RollbackOnError", func(t *testing.T) {
+		f := newFixture(t)
+		user := f.putUser(t, "1", 10)
+		card := f.putCard(t, user.Id, 1)
+
+		boom := errors.New("boom")
+		err := f.WithTx(func(tx Store) error {
+			if err := tx.Users().AddUserCurrency(user.Id, CurrencyCoin, 5); err != nil {
+				return err
+			}
+			return tx.WithTx(func(tx Store) error {
+				if err := tx.Cards().UpdateCardLevel(card.Id, 7); err != nil {
+					return err
+				}
+				return boom
+			})
+		})
+		if !errors.Is(err, boom) {
+			t.Fatalf("WithTx returned %v, want %v", err, boom)
+		}
+
+		if got := coin(t, f, user.Id); got != 10 {
+			t.Errorf("coin = %d, want 10", got)
+		}
+		got, err := f.Cards().GetCardById(card.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Lvl != 1 {
+			t.Errorf("lvl = %d, want 1", got.Lvl)
+		}
+	})
+
+	t.Run("ConcurrentWithdraw", func(t *testing.T) {
+		f := newFixture(t)
+		user := f.putUser(t, "1", 100)
+		card := f.putCard(t, user.Id, 1)
+		if err := f.Cards().UpdateCardMining(card.Id, 12.7, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		// Each worker does what the withdraw handler does: lock the card,
+		// then move its whole coins to the owner. Only the first may see them.
+		errEmpty := errors.New("nothing to withdraw")
+		const workers = 20
+		errs := make(chan error, workers)
+		var wg sync.WaitGroup
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- f.WithTx(func(tx Store) error {
+					card, err := tx.Cards().GetCardByIdForUpdate(card.Id)
+					if err != nil {
+						return err
+					}
+					whole := math.Floor(float64(card.Balance))
+					if whole < 1 {
+						return errEmpty
+					}
+					if err := tx.Cards().SubtractCardBalance(card.Id, float32(whole)); err != nil {
+						return err
+					}
+					return tx.Users().AddUserCurrency(user.Id, CurrencyCoin, int64(whole))
+				})
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		var ok int
+		for err := range errs {
+			switch {
+			case err == nil:
+				ok++
+			case !errors.Is(err, errEmpty):
+				t.Errorf("withdraw: %v", err)
+			}
+		}
+		if ok != 1 {
+			t.Errorf("%d withdrawals succeeded, want 1", ok)
+		}
+		if got := coin(t, f, user.Id); got != 112 {
+			t.Errorf("coin = %d, want 112", got)
+		}
+		left, err := f.Cards().GetCardById(card.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(float64(left.Balance)-0.7) > 1e-4 {
+			t.Errorf("card balance = %v, want 0.7", left.Balance)
+		}
+	})
+
+	t.Run("IdempotencyKeys", func(t *testing.T) {
+		f := newFixture(t)
+		keys := f.Idempotency()
+
+		for i, want := range []bool{true, false} {
+			reserved, err := keys.ReserveIdempotencyKey("1", "k", "hash", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reserved != want {
+				t.Fatalf("reserve #%d = %v, want %v", i+1, reserved, want)
+			}
+		}
+		if reserved, err := keys.ReserveIdempotencyKey("2", "k", "hash", time.Hour); err != nil || !reserved {
+			t.Fatalf("another user's key: reserved = %v, err = %v", reserved, err)
+		}
+
+		if err := keys.CompleteIdempotencyKey("1", "k", 201, `{"A":["b"]}`, []byte("body")); err != nil {
+			t.Fatal(err)
+		}
+		record, err := keys.GetIdempotencyRecord("1", "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.RequestHash != "hash" || record.StatusCode == nil || *record.StatusCode != 201 || string(record.Body) != "body" {
+			t.Errorf("record = %+v", record)
+		}
+
+		if err := keys.ReleaseIdempotencyKey("1", "k"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := keys.GetIdempotencyRecord("1", "k"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("released key: got %v, want sql.ErrNoRows", err)
+		}
+	})
+
+	t.Run("LedgerCursor", func(t *testing.T) {
+		f := newFixture(t)
+		user := f.putUser(t, "1", 0)
+		other := f.putUser(t, "2", 0)
+
+		for i := range 5 {
+			for _, id := range []int{user.Id, other.Id} {
+				err := f.Ledger().InsertLedgerEntry(LedgerEntry{
+					TransferId: strconv.Itoa(id) + "-" + strconv.Itoa(i),
+					Account:    "user:" + strconv.Itoa(id),
+					UserId:     &id,
+					Currency:   CurrencyCoin,
+					Delta:      int64(i),
+					Reason:     "test",
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		var pages [][]int64
+		var before int64
+		for {
+			entries, err := f.Ledger().GetUserLedger(user.Id, before, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) == 0 {
+				break
+			}
+			var ids []int64
+			for _, e := range entries {
+				if e.UserId == nil || *e.UserId != user.Id {
+					t.Fatalf("entry %d belongs to another user", e.Id)
+				}
+				if before > 0 && e.Id >= before {
+					t.Fatalf("entry %d is not before cursor %d", e.Id, before)
+				}
+				if len(ids) > 0 && e.Id >= ids[len(ids)-1] {
+					t.Fatalf("entries out of order: %d after %d", e.Id, ids[len(ids)-1])
+				}
+				ids = append(ids, e.Id)
+			}
+			pages = append(pages, ids)
+			before = ids[len(ids)-1]
+		}
+
+		if len(pages) != 3 || len(pages[0]) != 2 || len(pages[1]) != 2 || len(pages[2]) != 1 {
+			t.Errorf("pages = %v, want sizes 2, 2, 1", pages)
+		}
+	})
+
+	t.Run("SearchListingsPaging", func(t *testing.T) {
+		f := newFixture(t)
+		seller := f.putUser(t, "1", 0)
+
+		var ids []int64
+		for _, price := range []int64{30, 10, 20, 10, 40} {
+			card := f.putCard(t, seller.Id, 1)
+			l, err := f.Market().CreateListing(Listing{
+				SellerId: seller.Id, CardId: card.Id, Currency: CurrencyCoin, Price: price,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, l.Id)
+		}
+		// Inactive listings never show up.
+		card := f.putCard(t, seller.Id, 1)
+		sold, err := f.Market().CreateListing(Listing{SellerId: seller.Id, CardId: card.Id, Currency: CurrencyCoin, Price: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Market().UpdateListingStatus(sold.Id, ListingSold, &seller.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			sort ListingSort
+			want []int64
+		}{
+			{SortNewest, []int64{ids[4], ids[3], ids[2], ids[1], ids[0]}},
+			{SortPriceAsc, []int64{ids[1], ids[3], ids[2], ids[0], ids[4]}},
+			{SortPriceDesc, []int64{ids[4], ids[0], ids[2], ids[3], ids[1]}},
+		}
+		for _, tt := range tests {
+			t.Run(string(tt.sort), func(t *testing.T) {
+				var got []int64
+				var after *Listing
+				for page := 0; ; page++ {
+					if page > len(tt.want) {
+						t.Fatal("paging does not end")
+					}
+					listings, err := f.Market().SearchListings(ListingQuery{Sort: tt.sort, After: after, Limit: 2})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(listings) == 0 {
+						break
+					}
+					for _, l := range listings {
+						if l.Card.Id != l.CardId {
+							t.Errorf("listing %d joined card %d, want %d", l.Id, l.Card.Id, l.CardId)
+						}
+						got = append(got, l.Id)
+					}
+					after = &listings[len(listings)-1].Listing
+				}
+
+				if len(got) != len(tt.want) {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+				for i := range got {
+					if got[i] != tt.want[i] {
+						t.Fatalf("got %v, want %v", got, tt.want)
+					}
+				}
+			})
+		}
+	})
+}
+
+func coin(t *testing.T, store Store, userId int) int64 {
+	t.Helper()
+	amount, err := store.Users().GetUserCurrency(userId, CurrencyCoin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amount
+}
@@ -0,0 +1,145 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type TradeStatus string
+
+const (
+	TradePending   TradeStatus = "pending"
+	TradeAccepted  TradeStatus = "accepted"
+	TradeRejected  TradeStatus = "rejected"
+	TradeCancelled TradeStatus = "cancelled"
+	TradeExpired   TradeStatus = "expired"
+)
+
+// TradeSide says who gives an item: the sender's offer or what the sender
+// asks the recipient for in return.
+type TradeSide string
+
+const (
+	TradeOffered   TradeSide = "offer"
+	TradeRequested TradeSide = "request"
+)
+
+type TradeOffer struct {
+	Id         int64       `db:"id"`
+	FromUserId int         `db:"fromUserId"`
+	ToUserId   int         `db:"toUserId"`
+	Status     TradeStatus `db:"status"`
+	ExpiresAt  time.Time   `db:"expiresAt"`
+	CreatedAt  time.Time   `db:"createdAt"`
+	UpdatedAt  time.Time   `db:"updatedAt"`
+	Items      []TradeItem `db:"-"`
+}
+
+// TradeItem is either a card or an amount of one currency.
+type TradeItem struct {
+	Id       int64     `db:"id"`
+	TradeId  int64     `db:"tradeId"`
+	Side     TradeSide `db:"side"`
+	CardId   *int      `db:"cardId"`
+	Currency *Currency `db:"currency"`
+	Amount   int64     `db:"amount"`
+}
+
+func (r mysqlTrades) CreateTrade(t TradeOffer) (TradeOffer, error) {
+	res, err := r.db.Exec(`
+		INSERT INTO trade_offers (fromUserId, toUserId, status, expiresAt, createdAt, updatedAt)
+		VALUES (?, ?, ?, ?, NOW(), NOW())`,
+		t.FromUserId, t.ToUserId, TradePending, t.ExpiresAt)
+	if err != nil {
+		return TradeOffer{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return TradeOffer{}, err
+	}
+
+	for _, item := range t.Items {
+		_, err := r.db.Exec(`
+			INSERT INTO trade_offer_items (tradeId, side, cardId, currency, amount)
+			VALUES (?, ?, ?, ?, ?)`,
+			id, item.Side, item.CardId, item.Currency, item.Amount)
+		if err != nil {
+			return TradeOffer{}, err
+		}
+	}
+	return r.getTrade(id, "")
+}
+
+func (r mysqlTrades) GetTradeForUpdate(id int64) (TradeOffer, error) {
+	return r.getTrade(id, " FOR UPDATE")
+}
+
+func (r mysqlTrades) getTrade(id int64, lock string) (TradeOffer, error) {
+	var t TradeOffer
+	if err := sqlx.Get(r.db, &t, "SELECT * FROM trade_offers WHERE id = ?"+lock, id); err != nil {
+		return TradeOffer{}, err
+	}
+	err := sqlx.Select(r.db, &t.Items, "SELECT * FROM trade_offer_items WHERE tradeId = ? ORDER BY id", id)
+	return t, err
+}
+
+func (r mysqlTrades) GetUserPendingTrades(userId int) ([]TradeOffer, error) {
+	var trades []TradeOffer
+	err := sqlx.Select(r.db, &trades, `
+		SELECT * FROM trade_offers
+		WHERE (fromUserId = ? OR toUserId = ?) AND status = ?
+		ORDER BY id DESC`, userId, userId, TradePending)
+	if err != nil || len(trades) == 0 {
+		return trades, err
+	}
+
+	ids := make([]int64, len(trades))
+	for i, t := range trades {
+		ids[i] = t.Id
+	}
+	query, args, err := sqlx.In("SELECT * FROM trade_offer_items WHERE tradeId IN (?) ORDER BY id", ids)
+	if err != nil {
+		return nil, err
+	}
+	var items []TradeItem
+	if err := sqlx.Select(r.db, &items, query, args...); err != nil {
+		return nil, err
+	}
+
+	byTrade := make(map[int64]int, len(trades))
+	for i, t := range trades {
+		byTrade[t.Id] = i
+	}
+	for _, item := range items {
+		i := byTrade[item.TradeId]
+		trades[i].Items = append(trades[i].Items, item)
+	}
+	return trades, nil
+}
+
+func (r mysqlTrades) GetExpiredTradeIds(now time.Time, limit int) ([]int64, error) {
+	var ids []int64
+	err := sqlx.Select(r.db, &ids, `
+		SELECT id FROM trade_offers
+		WHERE status = ? AND expiresAt <= ?
+		ORDER BY id LIMIT ?`, TradePending, now, limit)
+	return ids, err
+}
+
+func (r mysqlTrades) IsCardInTrade(cardId int) (bool, error) {
+	var n int
+	err := sqlx.Get(r.db, &n, `
+		SELECT COUNT(*) FROM trade_offer_items i
+		JOIN trade_offers t ON t.id = i.tradeId
+		WHERE i.cardId = ? AND i.side = ? AND t.status = ?`, cardId, TradeOffered, TradePending)
+	return n > 0, err
+}
+
+func (r mysqlTrades) UpdateTradeStatus(id int64, status TradeStatus) error {
+	_, err := r.db.Exec(`
+		UPDATE trade_offers
+		SET status = ?, updatedAt = NOW()
+		WHERE id = ?`, status, id)
+	return err
+}
@@ -2,6 +2,8 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -33,42 +35,100 @@ type User struct {
 	Coin           int       `db:"coin"`
 }
 
-func GetUser(db *sqlx.DB, chatId string) (User, error) {
+var ErrUserNotFound = errors.New("user not found")
+
+type Currency string
+
+const (
+	CurrencyBalance Currency = "balance"
+	CurrencyCoin    Currency = "coin"
+	CurrencyGems    Currency = "gems"
+	CurrencyChests  Currency = "chests"
+	CurrencyFreeze  Currency = "freeze"
+)
+
+func (c Currency) column() (string, error) {
+	switch c {
+	case CurrencyBalance, CurrencyCoin, CurrencyGems, CurrencyChests, CurrencyFreeze:
+		return string(c), nil
+	}
+	return "", fmt.Errorf("unknown currency %q", string(c))
+}
+
+type InsufficientFundsError struct {
+	Currency Currency
+	Amount   int64
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("insufficient %s: need %d", e.Currency, e.Amount)
+}
+
+func (r mysqlUsers) GetUser(chatId string) (User, error) {
 	var user User
-	err := db.Get(&user, "SELECT * FROM users WHERE chatId = ?", chatId)
+	err := sqlx.Get(r.db, &user, "SELECT * FROM users WHERE chatId = ?", chatId)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return User{}, nil
+			return User{}, ErrUserNotFound
 		}
 		return User{}, err
 	}
 	return user, nil
 }
 
-func UpdateUserKeys(db *sqlx.DB, userId int, keys int) error {
-	_, err := db.Exec("UPDATE users SET chests = ?, updatedAt = NOW() WHERE id = ?", keys, userId)
-	return err
+func (r mysqlUsers) GetUserForUpdate(chatId string) (User, error) {
+	var user User
+	err := sqlx.Get(r.db, &user, "SELECT * FROM users WHERE chatId = ? FOR UPDATE", chatId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return User{}, ErrUserNotFound
+		}
+		return User{}, err
+	}
+	return user, nil
 }
 
-func UpdateUserBalance(db *sqlx.DB, userId int, balance uint64) error {
-	_, err := db.Exec("UPDATE users SET balance = ?, updatedAt = NOW() WHERE id = ?", balance, userId)
-	return err
-}
+func (r mysqlUsers) AddUserCurrency(userId int, currency Currency, delta int64) error {
+	column, err := currency.column()
+	if err != nil {
+		return err
+	}
+	if delta == 0 {
+		return nil
+	}
 
-func UpdateUserGems(db *sqlx.DB, userId int, gems int) error {
-	_, err := db.Exec("UPDATE users SET gems = ?, updatedAt = NOW() WHERE id = ?", gems, userId)
-	return err
-}
+	if delta > 0 {
+		_, err := r.db.Exec(`
+			UPDATE users 
+			SET `+column+` = `+column+` + ?, updatedAt = NOW() 
+			WHERE id = ?`, delta, userId)
+		return err
+	}
 
-func UpdateUserCoins(db *sqlx.DB, userId int, coins int) error {
-	_, err := db.Exec("UPDATE users SET coin = ?, updatedAt = NOW() WHERE id = ?", coins, userId)
-	return err
+	res, err := r.db.Exec(`
+		UPDATE users 
+		SET `+column+` = `+column+` - ?, updatedAt = NOW() 
+		WHERE id = ? AND `+column+` >= ?`, -delta, userId, -delta)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return &InsufficientFundsError{Currency: currency, Amount: -delta}
+	}
+	return nil
 }
 
-func DecrementUserFreeze(db *sqlx.DB, userId int) error {
-	_, err := db.Exec(`
-		UPDATE users 
-		SET freeze = freeze - 1, updatedAt = NOW() 
-		WHERE id = ? AND freeze > 0`, userId)
-	return err
+func (r mysqlUsers) GetUserCurrency(userId int, currency Currency) (int64, error) {
+	column, err := currency.column()
+	if err != nil {
+		return 0, err
+	}
+
+	var amount int64
+	err = sqlx.Get(r.db, &amount, "SELECT "+column+" FROM users WHERE id = ?", userId)
+	return amount, err
 }
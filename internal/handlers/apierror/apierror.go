@@ -0,0 +1,162 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// LegacyHeader lets old clients opt back into the pre-envelope responses:
+// 200 OK with {"status": "..."} for domain outcomes and plain-text bodies
+// for everything else.
+const LegacyHeader = "X-Legacy-Errors"
+
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Details map[string]any
+
+	cause        error
+	legacyStatus string
+	legacyCode   int
+	legacyText   string
+}
+
+type body struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+var (
+	ErrBadRequest   = &Error{Status: http.StatusBadRequest, Code: "bad_request", Message: "Invalid request"}
+	ErrValidation   = &Error{Status: http.StatusBadRequest, Code: "validation_failed", Message: "Invalid request body"}
+	ErrBodyTooLarge = &Error{Status: http.StatusRequestEntityTooLarge, Code: "body_too_large", Message: "Request body is too large"}
+	ErrUnauthorized = &Error{Status: http.StatusUnauthorized, Code: "unauthorized", Message: "Unauthorized"}
+	ErrForbidden    = &Error{Status: http.StatusForbidden, Code: "forbidden", Message: "Forbidden"}
+	ErrConflict     = &Error{Status: http.StatusConflict, Code: "conflict", Message: "Conflict"}
+	ErrInternal     = &Error{Status: http.StatusInternalServerError, Code: "internal", Message: "Internal server error"}
+	ErrRateLimited  = &Error{Status: http.StatusTooManyRequests, Code: "rate_limited", Message: "Too many requests"}
+
+	ErrIdempotencyKeyTooLong = &Error{Status: http.StatusBadRequest, Code: "idempotency_key_too_long", Message: "Idempotency-Key is too long"}
+	ErrIdempotencyKeyReused  = &Error{Status: http.StatusConflict, Code: "idempotency_key_reused", Message: "Idempotency-Key was already used for a different request"}
+	ErrIdempotencyInProgress = &Error{Status: http.StatusConflict, Code: "idempotency_in_progress", Message: "A request with this Idempotency-Key is still in progress"}
+
+	ErrUserNotFound    = &Error{Status: http.StatusNotFound, Code: "user_not_found", Message: "User not found"}
+	ErrCardNotFound    = &Error{Status: http.StatusNotFound, Code: "card_not_found", Message: "Card not found"}
+	ErrStandNotFound   = &Error{Status: http.StatusNotFound, Code: "stand_not_found", Message: "Stand not found"}
+	ErrListingNotFound = &Error{Status: http.StatusNotFound, Code: "listing_not_found", Message: "Listing not found"}
+	ErrTradeNotFound   = &Error{Status: http.StatusNotFound, Code: "trade_not_found", Message: "Trade not found"}
+
+	ErrNotOwner           = &Error{Status: http.StatusForbidden, Code: "not_owner", Message: "Card belongs to another user", legacyStatus: "dontHaveGpu"}
+	ErrStandNotOwned      = &Error{Status: http.StatusForbidden, Code: "stand_not_owned", Message: "Stand belongs to another user", legacyStatus: "dontHaveStand"}
+	ErrInsufficientFunds  = &Error{Status: http.StatusUnprocessableEntity, Code: "insufficient_funds", Message: "Insufficient funds"}
+	ErrSlotLimit          = &Error{Status: http.StatusUnprocessableEntity, Code: "slot_limit", Message: "Slot limit reached", legacyStatus: "maxSlots"}
+	ErrAlreadyInstalled   = &Error{Status: http.StatusConflict, Code: "already_installed", Message: "Stand already has a card", legacyStatus: "alreadyInstalled"}
+	ErrInstalledElsewhere = &Error{Status: http.StatusConflict, Code: "installed_elsewhere", Message: "Card is installed in another stand", legacyStatus: "alreadyInstalledElsewhere"}
+	ErrNotInstalled       = &Error{Status: http.StatusConflict, Code: "not_installed", Message: "Card is not installed", legacyStatus: "dontHaveStand"}
+	ErrStandEmpty         = &Error{Status: http.StatusConflict, Code: "stand_empty", Message: "Stand has no card", legacyStatus: "emptyStand"}
+	ErrFuelFull           = &Error{Status: http.StatusConflict, Code: "fuel_full", Message: "Card fuel is already full", legacyStatus: "alreadyFull"}
+	ErrCardInstalled      = &Error{Status: http.StatusConflict, Code: "card_installed", Message: "Card is installed in a stand"}
+	ErrLevelMismatch      = &Error{Status: http.StatusConflict, Code: "level_mismatch", Message: "Cards must all have the same level"}
+	ErrMaxLevel           = &Error{Status: http.StatusConflict, Code: "max_level", Message: "Card is already at the maximum level"}
+	ErrNothingToWithdraw  = &Error{Status: http.StatusConflict, Code: "nothing_to_withdraw", Message: "Card has no balance to withdraw", legacyStatus: "noBalance"}
+	ErrAlreadyListed      = &Error{Status: http.StatusConflict, Code: "already_listed", Message: "Card is already for sale"}
+	ErrListingNotActive   = &Error{Status: http.StatusConflict, Code: "listing_not_active", Message: "Listing is no longer for sale"}
+	ErrListingNotOwned    = &Error{Status: http.StatusForbidden, Code: "listing_not_owned", Message: "Listing belongs to another user"}
+	ErrOwnListing         = &Error{Status: http.StatusConflict, Code: "own_listing", Message: "Cannot buy your own listing"}
+	ErrCardInTrade        = &Error{Status: http.StatusConflict, Code: "card_in_trade", Message: "Card is held in a pending trade"}
+	ErrTradeNotOwned      = &Error{Status: http.StatusForbidden, Code: "trade_not_owned", Message: "Trade belongs to other users"}
+	ErrTradeNotPending    = &Error{Status: http.StatusConflict, Code: "trade_not_pending", Message: "Trade is no longer pending"}
+	ErrTradeExpired       = &Error{Status: http.StatusConflict, Code: "trade_expired", Message: "Trade has expired"}
+)
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+func (e *Error) WithMessage(message string) *Error {
+	c := *e
+	c.Message = message
+	return &c
+}
+
+func (e *Error) WithDetails(details map[string]any) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// WithCause records the underlying error for logging. It is never sent to
+// the client.
+func (e *Error) WithCause(err error) *Error {
+	c := *e
+	c.cause = err
+	return &c
+}
+
+// WithLegacyStatus sets the {"status": ...} string legacy clients receive.
+func (e *Error) WithLegacyStatus(status string) *Error {
+	c := *e
+	c.legacyStatus = status
+	return &c
+}
+
+// WithLegacyText sets the plain-text status and body legacy clients receive.
+func (e *Error) WithLegacyText(status int, text string) *Error {
+	c := *e
+	c.legacyStatus = ""
+	c.legacyCode = status
+	c.legacyText = text
+	return &c
+}
+
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return ErrInternal
+}
+
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	e := From(err)
+
+	if legacy, _ := strconv.ParseBool(r.Header.Get(LegacyHeader)); legacy {
+		writeLegacy(w, e)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(body{
+		Code:    e.Code,
+		Message: e.Message,
+		Details: e.Details,
+	})
+}
+
+func writeLegacy(w http.ResponseWriter, e *Error) {
+	if e.legacyStatus != "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": e.legacyStatus})
+		return
+	}
+
+	status, text := e.Status, e.Message
+	if e.legacyCode != 0 {
+		status, text = e.legacyCode, e.legacyText
+	}
+	http.Error(w, text, status)
+}
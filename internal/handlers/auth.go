@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+
+	"example.com/myapp/internal/auth"
+	"example.com/myapp/internal/handlers/apierror"
+	"github.com/go-chi/chi/v5"
+)
+
+func authorizedChatId(w http.ResponseWriter, r *http.Request, param string) (string, bool) {
+	chatId := auth.ChatId(r.Context())
+	if chatId == "" {
+		writeError(w, r, apierror.ErrUnauthorized)
+		return "", false
+	}
+
+	if claimed := chi.URLParam(r, param); claimed != "" && claimed != chatId {
+		writeError(w, r, apierror.ErrForbidden)
+		return "", false
+	}
+	return chatId, true
+}
+
+func authorizedBodyChatId(w http.ResponseWriter, r *http.Request, claimed string) (string, bool) {
+	chatId := auth.ChatId(r.Context())
+	if chatId == "" {
+		writeError(w, r, apierror.ErrUnauthorized)
+		return "", false
+	}
+
+	if claimed != chatId {
+		writeError(w, r, apierror.ErrForbidden)
+		return "", false
+	}
+	return chatId, true
+}
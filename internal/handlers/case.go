@@ -6,9 +6,12 @@ import (
 	"net/http"
 	"time"
 
+	"example.com/myapp/internal/config"
 	"example.com/myapp/internal/database"
-	"github.com/go-chi/chi/v5"
-	"github.com/jmoiron/sqlx"
+	"example.com/myapp/internal/handlers/apierror"
+	"example.com/myapp/internal/ledger"
+	"example.com/myapp/internal/logging"
+	"example.com/myapp/internal/metrics"
 )
 
 type CaseOpenResponse struct {
@@ -17,63 +20,87 @@ type CaseOpenResponse struct {
 	KeysLeft   int    `json:"keys_left"`
 }
 
-func OpenCaseHandler(db *sqlx.DB) http.HandlerFunc {
+func OpenCaseHandler(store database.Store, game config.Game) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		chatId := chi.URLParam(r, "chatId")
-		user, err := database.GetUser(db, chatId)
-		if err != nil {
-			http.Error(w, "User not found", http.StatusNotFound)
-			return
-		}
-
-		if user.Chests < 1 {
-			http.Error(w, "Not enough chests", http.StatusBadRequest)
-			return
-		}
-
-		user.Chests -= 1
-		err = database.UpdateUserKeys(db, user.Id, user.Chests)
-		if err != nil {
-			http.Error(w, "Failed to update chests", http.StatusInternalServerError)
+		chatId, ok := authorizedChatId(w, r, "chatId")
+		if !ok {
 			return
 		}
 
-		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-		rewardRoll := rng.Intn(3)
-
 		var rewardType string
 		var amount uint64
+		var keysLeft int
 
-		switch rewardRoll {
-		case 0:
-			rewardType = "gems"
-			amount = uint64(rng.Intn(10) + 1)
-			err = database.UpdateUserGems(db, user.Id, user.Gems+int(amount))
+		err := store.WithTx(func(tx database.Store) error {
+			user, err := tx.Users().GetUserForUpdate(chatId)
 			if err != nil {
-				http.Error(w, "Failed to update gems", http.StatusInternalServerError)
-				return
+				return err
 			}
-		case 1:
-			rewardType = "balance"
-			amount = uint64(rng.Intn(9001) + 1000)
-			err = database.UpdateUserBalance(db, user.Id, user.Balance+amount)
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+			err = ledger.Apply(tx, ledger.Posting{
+				UserId:   user.Id,
+				Currency: database.CurrencyChests,
+				Delta:    -1,
+				Reason:   ledger.ReasonCaseOpen,
+			})
 			if err != nil {
-				http.Error(w, "Failed to update balance", http.StatusInternalServerError)
-				return
+				if apiErr, ok := insufficientFunds(err); ok {
+					return apiErr.WithLegacyText(http.StatusBadRequest, "Not enough chests")
+				}
+				return apierror.ErrInternal.WithMessage("Failed to update chests").WithCause(err)
+			}
+			keysLeft = user.Chests - 1
+
+			rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+			rewardRoll := rng.Intn(3)
+
+			switch rewardRoll {
+			case 0:
+				rewardType = "gems"
+				amount = uint64(rng.Intn(game.CaseGemsMax-game.CaseGemsMin+1) + game.CaseGemsMin)
+				err = ledger.Apply(tx, ledger.Posting{
+					UserId:   user.Id,
+					Currency: database.CurrencyGems,
+					Delta:    int64(amount),
+					Reason:   ledger.ReasonCaseOpen,
+				})
+				if err != nil {
+					return apierror.ErrInternal.WithMessage("Failed to update gems").WithCause(err)
+				}
+			case 1:
+				rewardType = "balance"
+				amount = uint64(rng.Intn(game.CaseBalanceMax-game.CaseBalanceMin+1) + game.CaseBalanceMin)
+				err = ledger.Apply(tx, ledger.Posting{
+					UserId:   user.Id,
+					Currency: database.CurrencyBalance,
+					Delta:    int64(amount),
+					Reason:   ledger.ReasonCaseOpen,
+				})
+				if err != nil {
+					return apierror.ErrInternal.WithMessage("Failed to update balance").WithCause(err)
+				}
+			default:
+				rewardType = "nothing"
+				amount = 0
 			}
-		default:
-			rewardType = "nothing"
-			amount = 0
+			return nil
+		})
+		if err != nil {
+			writeError(w, r, err)
+			return
 		}
 
+		metrics.CaseOpened(rewardType)
+
 		resp := CaseOpenResponse{
 			RewardType: rewardType,
 			Amount:     amount,
-			KeysLeft:   user.Chests,
+			KeysLeft:   keysLeft,
 		}
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			writeError(w, r, apierror.ErrInternal.WithMessage("Failed to encode response").WithCause(err))
 			return
 		}
 	}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"example.com/myapp/internal/handlers/apierror"
+	"example.com/myapp/internal/validate"
+)
+
+// MaxBodyBytes caps the size of JSON request bodies.
+const MaxBodyBytes = 64 << 10
+
+// decodeJSON reads a single JSON object from the body into dst and checks
+// its validate tags. On failure it writes the error response, listing the
+// offending fields, and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		writeError(w, r, decodeError(err))
+		return false
+	}
+	if dec.More() {
+		writeError(w, r, apierror.ErrBadRequest.WithMessage("Request body must contain a single JSON object"))
+		return false
+	}
+
+	if errs := validate.Struct(dst); len(errs) > 0 {
+		writeError(w, r, invalidFields(errs...))
+		return false
+	}
+	return true
+}
+
+func decodeError(err error) error {
+	var tooLarge *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &tooLarge):
+		return apierror.ErrBodyTooLarge
+	case errors.Is(err, io.EOF):
+		return apierror.ErrBadRequest.WithMessage("Request body is empty")
+	case errors.As(err, &typeErr):
+		return invalidFields(validate.FieldError{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: "must be " + jsonType(typeErr.Type),
+		})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return invalidFields(validate.FieldError{
+			Field:   strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`),
+			Rule:    "unknown",
+			Message: "is not allowed",
+		})
+	default:
+		return apierror.ErrBadRequest.WithMessage("Invalid request body").WithCause(err)
+	}
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+func invalidFields(errs ...validate.FieldError) *apierror.Error {
+	return apierror.ErrValidation.WithDetails(map[string]any{"fields": errs})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/handlers/apierror"
+	"example.com/myapp/internal/logging"
+)
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, database.ErrUserNotFound) {
+		err = apierror.ErrUserNotFound
+	} else if apiErr, ok := insufficientFunds(err); ok {
+		err = apiErr
+	}
+
+	apiErr := apierror.From(err)
+	args := []any{"code", apiErr.Code, "status", apiErr.Status, "err", err}
+	if cause := apiErr.Unwrap(); cause != nil {
+		args = append(args, "cause", cause)
+	}
+	logger := logging.FromContext(r.Context())
+	if apiErr.Status >= http.StatusInternalServerError {
+		logger.Error("request failed", args...)
+	} else {
+		logger.Debug("request rejected", args...)
+	}
+	apierror.Write(w, r, err)
+}
+
+func insufficientFunds(err error) (*apierror.Error, bool) {
+	var insufficient *database.InsufficientFundsError
+	if !errors.As(err, &insufficient) {
+		return nil, false
+	}
+
+	return apierror.ErrInsufficientFunds.WithDetails(map[string]any{
+		"currency": insufficient.Currency,
+		"amount":   insufficient.Amount,
+	}), true
+}
+
+// lookupError maps a failed lookup to notFound when no row matched and to
+// an internal error otherwise.
+func lookupError(err error, notFound *apierror.Error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFound
+	}
+	return apierror.ErrInternal.WithMessage("Database error").WithCause(err)
+}
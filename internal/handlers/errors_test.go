@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
+	"github.com/go-chi/chi/v5"
+)
+
+var errDatabaseDown = errors.New("database is down")
+
+// brokenStore fails every user or card lookup with errDatabaseDown, also
+// inside transactions.
+type brokenStore struct {
+	database.Store
+	users, cards bool
+}
+
+func (s brokenStore) Users() database.UserRepository {
+	if !s.users {
+		return s.Store.Users()
+	}
+	return brokenUsers{s.Store.Users()}
+}
+
+func (s brokenStore) Cards() database.CardRepository {
+	if !s.cards {
+		return s.Store.Cards()
+	}
+	return brokenCards{s.Store.Cards()}
+}
+
+func (s brokenStore) WithTx(fn func(tx database.Store) error) error {
+	return s.Store.WithTx(func(tx database.Store) error {
+		return fn(brokenStore{tx, s.users, s.cards})
+	})
+}
+
+type brokenUsers struct{ database.UserRepository }
+
+func (brokenUsers) GetUser(string) (database.User, error) {
+	return database.User{}, errDatabaseDown
+}
+
+func (brokenUsers) GetUserForUpdate(string) (database.User, error) {
+	return database.User{}, errDatabaseDown
+}
+
+type brokenCards struct{ database.CardRepository }
+
+func (brokenCards) GetCardById(int) (database.Card, error) {
+	return database.Card{}, errDatabaseDown
+}
+
+func (brokenCards) GetCardByIdForUpdate(int) (database.Card, error) {
+	return database.Card{}, errDatabaseDown
+}
+
+func TestNotFoundVersusFailure(t *testing.T) {
+	game := config.Game{MaxLevel: 5, FuseCards: 2, UpgradeCosts: []config.UpgradeCost{{Currency: "coin", Amount: 1}}}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		// user and card seed the store: the caller "42" and card 7.
+		user, card  bool
+		brokenUsers bool
+		brokenCards bool
+		wantStatus  int
+		wantCode    string
+	}{
+		{name: "user missing", method: http.MethodGet, path: "/user/42",
+			wantStatus: http.StatusNotFound, wantCode: "user_not_found"},
+		{name: "user lookup fails", method: http.MethodGet, path: "/user/42", user: true, brokenUsers: true,
+			wantStatus: http.StatusInternalServerError, wantCode: "internal"},
+		{name: "withdraw user missing", method: http.MethodPost, path: "/mining/cards/7/withdraw",
+			wantStatus: http.StatusNotFound, wantCode: "user_not_found"},
+		{name: "withdraw user lookup fails", method: http.MethodPost, path: "/mining/cards/7/withdraw", user: true, brokenUsers: true,
+			wantStatus: http.StatusInternalServerError, wantCode: "internal"},
+		{name: "withdraw card missing", method: http.MethodPost, path: "/mining/cards/7/withdraw", user: true,
+			wantStatus: http.StatusNotFound, wantCode: "card_not_found"},
+		{name: "withdraw card lookup fails", method: http.MethodPost, path: "/mining/cards/7/withdraw", user: true, card: true, brokenCards: true,
+			wantStatus: http.StatusInternalServerError, wantCode: "internal"},
+		{name: "upgrade card missing", method: http.MethodPost, path: "/mining/cards/7/upgrade", user: true,
+			wantStatus: http.StatusNotFound, wantCode: "card_not_found"},
+		{name: "upgrade card lookup fails", method: http.MethodPost, path: "/mining/cards/7/upgrade", user: true, card: true, brokenCards: true,
+			wantStatus: http.StatusInternalServerError, wantCode: "internal"},
+		{name: "install card missing", method: http.MethodPost, path: "/mining/installGpu", body: `{"userId":"42","standId":1,"cardId":7}`, user: true,
+			wantStatus: http.StatusNotFound, wantCode: "card_not_found"},
+		{name: "install card lookup fails", method: http.MethodPost, path: "/mining/installGpu", body: `{"userId":"42","standId":1,"cardId":7}`, user: true, card: true, brokenCards: true,
+			wantStatus: http.StatusInternalServerError, wantCode: "internal"},
+		{name: "fuse card missing", method: http.MethodPost, path: "/mining/cards/fuse", body: `{"userId":"42","cardIds":[7,8]}`, user: true,
+			wantStatus: http.StatusNotFound, wantCode: "card_not_found"},
+		{name: "fuse card lookup fails", method: http.MethodPost, path: "/mining/cards/fuse", body: `{"userId":"42","cardIds":[7,8]}`, user: true, card: true, brokenCards: true,
+			wantStatus: http.StatusInternalServerError, wantCode: "internal"},
+		{name: "pull card missing", method: http.MethodGet, path: "/mining/pullGpu/7/42", user: true,
+			wantStatus: http.StatusNotFound, wantCode: "card_not_found"},
+		{name: "pull card lookup fails", method: http.MethodGet, path: "/mining/pullGpu/7/42", user: true, card: true, brokenCards: true,
+			wantStatus: http.StatusInternalServerError, wantCode: "internal"},
+		{name: "gpu missing", method: http.MethodGet, path: "/mining/getGpuById/7",
+			wantStatus: http.StatusNotFound, wantCode: "card_not_found"},
+		{name: "gpu lookup fails", method: http.MethodGet, path: "/mining/getGpuById/7", card: true, brokenCards: true,
+			wantStatus: http.StatusInternalServerError, wantCode: "internal"},
+		{name: "listing card missing", method: http.MethodPost, path: "/market/listings", body: `{"userId":"42","cardId":7,"currency":"coin","price":10}`, user: true,
+			wantStatus: http.StatusNotFound, wantCode: "card_not_found"},
+		{name: "listing card lookup fails", method: http.MethodPost, path: "/market/listings", body: `{"userId":"42","cardId":7,"currency":"coin","price":10}`, user: true, card: true, brokenCards: true,
+			wantStatus: http.StatusInternalServerError, wantCode: "internal"},
+		{name: "trade card missing", method: http.MethodPost, path: "/trades", body: `{"userId":"42","toUserId":"43","offer":{"cardIds":[7]}}`, user: true,
+			wantStatus: http.StatusNotFound, wantCode: "card_not_found"},
+		{name: "trade card lookup fails", method: http.MethodPost, path: "/trades", body: `{"userId":"42","toUserId":"43","offer":{"cardIds":[7]}}`, user: true, card: true, brokenCards: true,
+			wantStatus: http.StatusInternalServerError, wantCode: "internal"},
+		{name: "requested trade card missing", method: http.MethodPost, path: "/trades", body: `{"userId":"42","toUserId":"43","request":{"cardIds":[7]}}`, user: true,
+			wantStatus: http.StatusNotFound, wantCode: "card_not_found"},
+		{name: "requested trade card lookup fails", method: http.MethodPost, path: "/trades", body: `{"userId":"42","toUserId":"43","request":{"cardIds":[7]}}`, user: true, card: true, brokenCards: true,
+			wantStatus: http.StatusInternalServerError, wantCode: "internal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := database.NewMemoryStore()
+			mem.PutUser(database.User{ChatId: "43"})
+			if tt.user {
+				user := mem.PutUser(database.User{ChatId: "42", Coin: 100})
+				mem.PutStand(database.CardStand{UserId: user.Id})
+				if tt.card {
+					mem.PutCard(database.Card{Id: 7, UserId: user.Id, Lvl: 1})
+					mem.PutCard(database.Card{Id: 8, UserId: user.Id, Lvl: 1})
+				}
+			} else if tt.card {
+				mem.PutCard(database.Card{Id: 7, UserId: 99, Lvl: 1})
+			}
+			store := brokenStore{Store: mem, users: tt.brokenUsers, cards: tt.brokenCards}
+
+			h := newRouter(t, func(r chi.Router) {
+				r.Get("/user/{chatId}", GetUserHandler(store))
+				r.Get("/mining/getGpuById/{gpuId}", GetGpuByIdHandler(store))
+				r.Get("/mining/pullGpu/{gpuId}/{userId}", PullGpuHandler(store))
+				r.Post("/mining/installGpu", InstallGpuHandler(store))
+				r.Post("/mining/cards/{cardId}/withdraw", WithdrawBitcoinHandler(store))
+				r.Post("/mining/cards/{cardId}/upgrade", UpgradeGpuHandler(store, game))
+				r.Post("/mining/cards/fuse", FuseGpuHandler(store, game))
+				r.Post("/market/listings", CreateListingHandler(store, game))
+				r.Post("/trades", CreateTradeHandler(store, game))
+			})
+
+			w := do(h, tt.method, tt.path, "42", tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if code := errorCode(t, w); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestBuyListingCardLookupFails(t *testing.T) {
+	mem := database.NewMemoryStore()
+	mem.PutUser(database.User{ChatId: "42", Coin: 100})
+	seller := mem.PutUser(database.User{ChatId: "43"})
+	card := mem.PutCard(database.Card{UserId: seller.Id, Lvl: 1})
+	listing, err := mem.Market().CreateListing(database.Listing{
+		SellerId: seller.Id, CardId: card.Id, Currency: database.CurrencyCoin, Price: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := brokenStore{Store: mem, cards: true}
+
+	h := newRouter(t, func(r chi.Router) {
+		r.Post("/market/listings/{listingId}/buy", BuyListingHandler(store, config.Game{}))
+	})
+
+	w := do(h, http.MethodPost, fmt.Sprintf("/market/listings/%d/buy", listing.Id), "42", "")
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500: %s", w.Code, w.Body)
+	}
+}
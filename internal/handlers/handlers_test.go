@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/myapp/internal/auth"
+	"example.com/myapp/internal/auth/authtest"
+	"example.com/myapp/internal/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// newRouter returns a router with the middleware the handlers expect in
+// front of the routes that routes registers.
+func newRouter(t *testing.T, routes func(r chi.Router)) http.Handler {
+	t.Helper()
+	logger, err := logging.New(io.Discard, "error", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(logging.Middleware(logger))
+	r.Use(auth.Middleware(authtest.BotToken, time.Hour))
+	routes(r)
+	return r
+}
+
+// do sends a request as chatId and returns the recorded response.
+func do(h http.Handler, method, path, chatId, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "tma "+authtest.InitData(chatId))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+// errorCode returns the code of the error envelope in w.
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode error body %q: %v", w.Body.String(), err)
+	}
+	return body.Code
+}
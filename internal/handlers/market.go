@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/handlers/apierror"
+	"example.com/myapp/internal/ledger"
+	"example.com/myapp/internal/logging"
+	"example.com/myapp/internal/metrics"
+	"example.com/myapp/internal/mining"
+	"example.com/myapp/internal/validate"
+	"github.com/go-chi/chi/v5"
+)
+
+type CreateListingRequest struct {
+	UserId   string `json:"userId" validate:"required"`
+	CardId   int    `json:"cardId" validate:"required,min=1"`
+	Currency string `json:"currency" validate:"required"`
+	Price    int64  `json:"price" validate:"required,min=1"`
+}
+
+type ListingResponse struct {
+	Id        int64         `json:"id"`
+	SellerId  int           `json:"seller_id"`
+	Currency  string        `json:"currency"`
+	Price     int64         `json:"price"`
+	Status    string        `json:"status"`
+	Card      database.Card `json:"card"`
+	Income    float32       `json:"income"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+type ListingsResponse struct {
+	Listings   []ListingResponse `json:"listings"`
+	NextCursor *string           `json:"next_cursor"`
+}
+
+type BuyListingResponse struct {
+	Status  string          `json:"status"`
+	Listing ListingResponse `json:"listing"`
+	Paid    int64           `json:"paid"`
+	Fee     int64           `json:"fee"`
+}
+
+// marketCurrencies are the currencies a card can be priced in.
+var marketCurrencies = []database.Currency{database.CurrencyCoin, database.CurrencyBalance}
+
+func listingResponse(l database.Listing, card database.Card, game config.Game) ListingResponse {
+	return ListingResponse{
+		Id:        l.Id,
+		SellerId:  l.SellerId,
+		Currency:  string(l.Currency),
+		Price:     l.Price,
+		Status:    string(l.Status),
+		Card:      card,
+		Income:    mining.Income(card.Lvl, game),
+		CreatedAt: l.CreatedAt,
+	}
+}
+
+func marketCurrency(s string) (database.Currency, bool) {
+	for _, c := range marketCurrencies {
+		if string(c) == s {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// listingCursor is the last listing of a page: its id, preceded by its price
+// and a colon when the page is sorted by price.
+func listingCursor(l database.Listing, sort database.ListingSort) string {
+	id := strconv.FormatInt(l.Id, 10)
+	if sort == database.SortNewest {
+		return id
+	}
+	return strconv.FormatInt(l.Price, 10) + ":" + id
+}
+
+func parseListingCursor(s string, sort database.ListingSort) (*database.Listing, bool) {
+	var after database.Listing
+	idStr := s
+	if sort != database.SortNewest {
+		priceStr, rest, ok := strings.Cut(s, ":")
+		if !ok {
+			return nil, false
+		}
+		price, err := strconv.ParseInt(priceStr, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		after.Price = price
+		idStr = rest
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		return nil, false
+	}
+	after.Id = id
+	return &after, true
+}
+
+func ListListingsHandler(store database.Store, game config.Game) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authorizedChatId(w, r, "userId"); !ok {
+			return
+		}
+
+		query := r.URL.Query()
+		q := database.ListingQuery{Sort: database.SortNewest, Limit: 50}
+
+		if s := query.Get("currency"); s != "" {
+			currency, ok := marketCurrency(s)
+			if !ok {
+				writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid currency"))
+				return
+			}
+			q.Currency = currency
+		}
+
+		bounds := []struct {
+			name string
+			dst  *int64
+		}{
+			{"min_price", &q.MinPrice},
+			{"max_price", &q.MaxPrice},
+		}
+		for _, b := range bounds {
+			if s := query.Get(b.name); s != "" {
+				n, err := strconv.ParseInt(s, 10, 64)
+				if err != nil || n <= 0 {
+					writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid "+b.name))
+					return
+				}
+				*b.dst = n
+			}
+		}
+		levels := []struct {
+			name string
+			dst  *int
+		}{
+			{"min_level", &q.MinLvl},
+			{"max_level", &q.MaxLvl},
+		}
+		for _, b := range levels {
+			if s := query.Get(b.name); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil || n <= 0 {
+					writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid "+b.name))
+					return
+				}
+				*b.dst = n
+			}
+		}
+
+		if s := query.Get("sort"); s != "" {
+			switch database.ListingSort(s) {
+			case database.SortNewest, database.SortPriceAsc, database.SortPriceDesc:
+				q.Sort = database.ListingSort(s)
+			default:
+				writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid sort"))
+				return
+			}
+		}
+
+		if s := query.Get("cursor"); s != "" {
+			after, ok := parseListingCursor(s, q.Sort)
+			if !ok {
+				writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid cursor"))
+				return
+			}
+			q.After = after
+		}
+
+		if s := query.Get("limit"); s != "" {
+			l, err := strconv.Atoi(s)
+			if err != nil || l <= 0 || l > 200 {
+				writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid limit"))
+				return
+			}
+			q.Limit = l
+		}
+
+		limit := q.Limit
+		q.Limit++
+		listings, err := store.Market().SearchListings(q)
+		if err != nil {
+			writeError(w, r, apierror.ErrInternal.WithMessage("Failed to search listings").WithCause(err))
+			return
+		}
+
+		resp := ListingsResponse{Listings: make([]ListingResponse, 0, len(listings))}
+		if len(listings) > limit {
+			listings = listings[:limit]
+			next := listingCursor(listings[limit-1].Listing, q.Sort)
+			resp.NextCursor = &next
+		}
+		for _, l := range listings {
+			resp.Listings = append(resp.Listings, listingResponse(l.Listing, l.Card, game))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func CreateListingHandler(store database.Store, game config.Game) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateListingRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		currency, ok := marketCurrency(req.Currency)
+		if !ok {
+			writeError(w, r, invalidFields(validate.FieldError{
+				Field: "currency", Rule: "oneof", Message: "must be coin or balance",
+			}))
+			return
+		}
+
+		chatId, ok := authorizedBodyChatId(w, r, req.UserId)
+		if !ok {
+			return
+		}
+
+		var listing database.Listing
+		var card database.Card
+		err := store.WithTx(func(tx database.Store) error {
+			user, err := tx.Users().GetUserForUpdate(chatId)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+			card, err = tx.Cards().GetCardByIdForUpdate(req.CardId)
+			if err != nil {
+				return lookupError(err, apierror.ErrCardNotFound)
+			}
+			if card.UserId != user.Id {
+				return apierror.ErrNotOwner
+			}
+
+			installed, err := tx.Stands().IsCardInstalledElsewhere(card.Id)
+			if err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to check card stand").WithCause(err)
+			}
+			if installed {
+				return apierror.ErrCardInstalled
+			}
+			if err := checkNotInTrade(tx, card.Id); err != nil {
+				return err
+			}
+
+			listed, err := tx.Market().IsCardListed(card.Id)
+			if err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to check listings").WithCause(err)
+			}
+			if listed {
+				return apierror.ErrAlreadyListed
+			}
+
+			listing, err = tx.Market().CreateListing(database.Listing{
+				SellerId: user.Id,
+				CardId:   card.Id,
+				Currency: currency,
+				Price:    req.Price,
+			})
+			if err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to create listing").WithCause(err)
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listingResponse(listing, card, game))
+	}
+}
+
+// BuyListingHandler moves the card to the buyer and pays the seller the
+// price less game.MarketFee. Any balance left on the card goes to the seller
+// as coins first, as on install.
+func BuyListingHandler(store database.Store, game config.Game) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chatId, ok := authorizedChatId(w, r, "userId")
+		if !ok {
+			return
+		}
+
+		listingId, err := strconv.ParseInt(chi.URLParam(r, "listingId"), 10, 64)
+		if err != nil {
+			writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid listingId"))
+			return
+		}
+
+		var listing database.Listing
+		var card database.Card
+		var fee int64
+		var stale bool
+		err = store.WithTx(func(tx database.Store) error {
+			var err error
+			listing, err = tx.Market().GetListingForUpdate(listingId)
+			if err != nil {
+				return lookupError(err, apierror.ErrListingNotFound)
+			}
+			if listing.Status != database.ListingActive {
+				return apierror.ErrListingNotActive
+			}
+
+			buyer, err := tx.Users().GetUser(chatId)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", buyer.Id)
+
+			if listing.SellerId == buyer.Id {
+				return apierror.ErrOwnListing
+			}
+
+			card, err = tx.Cards().GetCardByIdForUpdate(listing.CardId)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return apierror.ErrInternal.WithMessage("Database error").WithCause(err)
+			}
+			if err != nil || card.UserId != listing.SellerId {
+				// The card is gone or changed hands outside the market. Take
+				// the listing down for good instead of rolling back.
+				stale = true
+				if err := tx.Market().UpdateListingStatus(listing.Id, database.ListingDelisted, nil); err != nil {
+					return apierror.ErrInternal.WithMessage("Failed to update listing").WithCause(err)
+				}
+				return nil
+			}
+
+			fee = int64(math.Floor(float64(listing.Price) * game.MarketFee))
+			ref := strconv.FormatInt(listing.Id, 10)
+			postings := []ledger.Posting{
+				{
+					UserId:       buyer.Id,
+					Currency:     listing.Currency,
+					Delta:        -listing.Price,
+					Reason:       ledger.ReasonMarketPurchase,
+					ReferenceId:  ref,
+					Counterparty: ledger.MarketAccount,
+				},
+				{
+					UserId:       listing.SellerId,
+					Currency:     listing.Currency,
+					Delta:        listing.Price - fee,
+					Reason:       ledger.ReasonMarketSale,
+					ReferenceId:  ref,
+					Counterparty: ledger.MarketAccount,
+				},
+			}
+			payout, err := ledger.CardPayout(tx, card, listing.SellerId, ledger.ReasonMarketSale)
+			if err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to pay out card balance").WithCause(err)
+			}
+			postings = append(postings, payout)
+			if err := ledger.ApplyAll(tx, postings); err != nil {
+				if apiErr, ok := insufficientFunds(err); ok {
+					return apiErr
+				}
+				return apierror.ErrInternal.WithMessage("Failed to pay for the listing").WithCause(err)
+			}
+
+			if err := tx.Cards().UpdateCardOwner(card.Id, buyer.Id); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to transfer card").WithCause(err)
+			}
+			if err := tx.Market().UpdateListingStatus(listing.Id, database.ListingSold, &buyer.Id); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to update listing").WithCause(err)
+			}
+
+			card.UserId = buyer.Id
+			card.Balance -= float32(payout.Delta)
+			listing.Status = database.ListingSold
+			return nil
+		})
+		if err == nil && stale {
+			err = apierror.ErrListingNotActive
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		metrics.MarketSale(string(listing.Currency), fee)
+
+		response := BuyListingResponse{
+			Status:  "success",
+			Listing: listingResponse(listing, card, game),
+			Paid:    listing.Price,
+			Fee:     fee,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func CancelListingHandler(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chatId, ok := authorizedChatId(w, r, "userId")
+		if !ok {
+			return
+		}
+
+		listingId, err := strconv.ParseInt(chi.URLParam(r, "listingId"), 10, 64)
+		if err != nil {
+			writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid listingId"))
+			return
+		}
+
+		err = store.WithTx(func(tx database.Store) error {
+			user, err := tx.Users().GetUser(chatId)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+			listing, err := tx.Market().GetListingForUpdate(listingId)
+			if err != nil {
+				return lookupError(err, apierror.ErrListingNotFound)
+			}
+			if listing.SellerId != user.Id {
+				return apierror.ErrListingNotOwned
+			}
+			if listing.Status != database.ListingActive {
+				return apierror.ErrListingNotActive
+			}
+
+			if err := tx.Market().UpdateListingStatus(listing.Id, database.ListingCancelled, nil); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to cancel listing").WithCause(err)
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(StatusResponse{Status: "success"})
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/ledger"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestBuyListingDelistsStaleListing(t *testing.T) {
+	tests := []struct {
+		name  string
+		stale func(store *database.MemoryStore, card database.Card)
+	}{
+		{"card deleted", func(store *database.MemoryStore, card database.Card) {
+			if err := store.Cards().DeleteCard(card.Id); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"card changed hands", func(store *database.MemoryStore, card database.Card) {
+			other := store.PutUser(database.User{ChatId: "44"})
+			card.UserId = other.Id
+			store.PutCard(card)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := database.NewMemoryStore()
+			buyer := store.PutUser(database.User{ChatId: "42", Coin: 100})
+			seller := store.PutUser(database.User{ChatId: "43"})
+			card := store.PutCard(database.Card{UserId: seller.Id, Lvl: 1})
+			listing, err := store.Market().CreateListing(database.Listing{
+				SellerId: seller.Id, CardId: card.Id, Currency: database.CurrencyCoin, Price: 50,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.stale(store, card)
+
+			h := newRouter(t, func(r chi.Router) {
+				r.Post("/market/listings/{listingId}/buy", BuyListingHandler(store, config.Game{MarketFee: 0.1}))
+			})
+			w := do(h, http.MethodPost, fmt.Sprintf("/market/listings/%d/buy", listing.Id), "42", "")
+			if w.Code != http.StatusConflict {
+				t.Fatalf("status = %d, want 409: %s", w.Code, w.Body)
+			}
+			if code := errorCode(t, w); code != "listing_not_active" {
+				t.Errorf("code = %q, want listing_not_active", code)
+			}
+
+			got, err := store.Market().GetListingForUpdate(listing.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != database.ListingDelisted {
+				t.Errorf("listing status = %q, want %q", got.Status, database.ListingDelisted)
+			}
+			if coin, _ := store.Users().GetUserCurrency(buyer.Id, database.CurrencyCoin); coin != 100 {
+				t.Errorf("buyer coin = %d, want 100", coin)
+			}
+		})
+	}
+}
+
+func TestBuyListing(t *testing.T) {
+	store := database.NewMemoryStore()
+	buyer := store.PutUser(database.User{ChatId: "42", Coin: 100})
+	seller := store.PutUser(database.User{ChatId: "43", Coin: 10})
+	card := store.PutCard(database.Card{UserId: seller.Id, Lvl: 1, Balance: 2.5})
+	listing, err := store.Market().CreateListing(database.Listing{
+		SellerId: seller.Id, CardId: card.Id, Currency: database.CurrencyCoin, Price: 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := newRouter(t, func(r chi.Router) {
+		r.Post("/market/listings/{listingId}/buy", BuyListingHandler(store, config.Game{MarketFee: 0.1}))
+	})
+	w := do(h, http.MethodPost, fmt.Sprintf("/market/listings/%d/buy", listing.Id), "42", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var resp BuyListingResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Paid != 50 || resp.Fee != 5 {
+		t.Errorf("paid %d with fee %d, want 50 and 5", resp.Paid, resp.Fee)
+	}
+
+	// The seller gets the price less the fee, plus the whole coins that
+	// were on the card.
+	if coin, _ := store.Users().GetUserCurrency(buyer.Id, database.CurrencyCoin); coin != 50 {
+		t.Errorf("buyer coin = %d, want 50", coin)
+	}
+	if coin, _ := store.Users().GetUserCurrency(seller.Id, database.CurrencyCoin); coin != 10+45+2 {
+		t.Errorf("seller coin = %d, want 57", coin)
+	}
+
+	var market int64
+	for _, e := range store.LedgerEntries() {
+		if e.Account == ledger.MarketAccount {
+			market += e.Delta
+		}
+		if e.Reason == string(ledger.ReasonGpuWithdraw) {
+			t.Errorf("sale recorded as a withdrawal: %+v", e)
+		}
+	}
+	if market != 5 {
+		t.Errorf("market account = %d, want the fee of 5", market)
+	}
+	entries, err := store.Ledger().GetUserLedger(seller.Id, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sales int64
+	for _, e := range entries {
+		if e.Reason == string(ledger.ReasonMarketSale) {
+			sales += e.Delta
+		}
+	}
+	if sales != 47 {
+		t.Errorf("seller market_sale entries total %d, want 47", sales)
+	}
+
+	got, err := store.Cards().GetCardById(card.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UserId != buyer.Id || got.Balance != 0.5 {
+		t.Errorf("card owner %d with balance %v, want %d with 0.5", got.UserId, got.Balance, buyer.Id)
+	}
+
+	sold, err := store.Market().GetListingForUpdate(listing.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sold.Status != database.ListingSold || sold.BuyerId == nil || *sold.BuyerId != buyer.Id {
+		t.Errorf("listing = %+v, want sold to %d", sold, buyer.Id)
+	}
+}
@@ -2,80 +2,177 @@ package handlers
 
 import (
 	"encoding/json"
-	"math"
+	"fmt"
+	"math/rand"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"example.com/myapp/internal/config"
 	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/handlers/apierror"
+	"example.com/myapp/internal/ledger"
+	"example.com/myapp/internal/logging"
+	"example.com/myapp/internal/metrics"
+	"example.com/myapp/internal/mining"
+	"example.com/myapp/internal/validate"
 	"github.com/go-chi/chi/v5"
-	"github.com/jmoiron/sqlx"
 )
 
 type SlotResponse struct {
-	Id   int            `json:"id"`
-	Card *database.Card `json:"card"`
+	Id     int            `json:"id"`
+	Card   *database.Card `json:"card"`
+	Mining *mining.Status `json:"mining"`
 }
 
 type InstallGpuRequest struct {
-	UserId  string `json:"userId"`
-	StandId int    `json:"standId"`
-	CardId  int    `json:"cardId"`
+	UserId  string `json:"userId" validate:"required"`
+	StandId int    `json:"standId" validate:"required,min=1"`
+	CardId  int    `json:"cardId" validate:"required,min=1"`
 }
 
-type BuyGpuSlotRequest struct {
+type BuySlotResponse struct {
 	Status string             `json:"status"`
 	Slot   database.CardStand `json:"slot"`
 }
 
 type FreezeGpuRequest struct {
-	UserId string `json:"userId"`
-	CardId int    `json:"cardId"`
+	UserId string `json:"userId" validate:"required"`
+	CardId int    `json:"cardId" validate:"required,min=1"`
 }
 
-func GetSlotsHandler(db *sqlx.DB) http.HandlerFunc {
+type FreezeGpuResponse struct {
+	Status string        `json:"status"`
+	Card   FrozenCard    `json:"card"`
+	User   FreezeBalance `json:"user"`
+}
+
+type FrozenCard struct {
+	Id     int `json:"id"`
+	Fuel   int `json:"fuel"`
+	UserId int `json:"userId"`
+}
+
+type FreezeBalance struct {
+	Id     int `json:"id"`
+	Freeze int `json:"freeze"`
+}
+
+type WithdrawResponse struct {
+	Status    string        `json:"status"`
+	User      CoinBalance   `json:"user"`
+	Card      WithdrawnCard `json:"card"`
+	Withdrawn float32       `json:"withdrawn"`
+}
+
+type CoinBalance struct {
+	Id   int `json:"id"`
+	Coin int `json:"coin"`
+}
+
+type WithdrawnCard struct {
+	Id      int     `json:"id"`
+	Balance float32 `json:"balance"`
+}
+
+type UpgradeGpuResponse struct {
+	Status   string       `json:"status"`
+	Card     UpgradedCard `json:"card"`
+	Cost     UpgradeCost  `json:"cost"`
+	Income   float32      `json:"income"`
+	NextCost *UpgradeCost `json:"nextCost"`
+}
+
+type UpgradedCard struct {
+	Id  int `json:"id"`
+	Lvl int `json:"lvl"`
+}
+
+type UpgradeCost struct {
+	Currency database.Currency `json:"currency"`
+	Amount   int64             `json:"amount"`
+}
+
+type FuseGpuRequest struct {
+	UserId  string `json:"userId" validate:"required"`
+	CardIds []int  `json:"cardIds" validate:"required,min=2"`
+}
+
+type FuseGpuResponse struct {
+	Status    string        `json:"status"`
+	Card      database.Card `json:"card"`
+	Income    float32       `json:"income"`
+	Consumed  []int         `json:"consumed"`
+	Withdrawn int64         `json:"withdrawn"`
+}
+
+type CardWithIncome struct {
+	Card   database.Card `json:"card"`
+	Income float32       `json:"income"`
+	Mining mining.Status `json:"mining"`
+}
+
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+func GetSlotsHandler(store database.Store, game config.Game) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userIdStr := chi.URLParam(r, "userId")
+		userIdStr, ok := authorizedChatId(w, r, "userId")
+		if !ok {
+			return
+		}
 
-		user, err := database.GetUser(db, userIdStr)
+		user, err := store.Users().GetUser(userIdStr)
 		if err != nil {
-			http.Error(w, "User not found", http.StatusNotFound)
+			writeError(w, r, err)
 			return
 		}
+		logging.AddAttrs(r.Context(), "user_id", user.Id)
 
-		stands, err := database.GetUserCardStands(db, user.Id)
+		stands, err := store.Stands().GetUserCardStands(user.Id)
 		if err != nil {
-			http.Error(w, "Failed to get slots", http.StatusInternalServerError)
+			writeError(w, r, apierror.ErrInternal.WithMessage("Failed to get slots").WithCause(err))
 			return
 		}
 
 		slots := make([]SlotResponse, 0, len(stands))
 		for _, stand := range stands {
-			slots = append(slots, SlotResponse{
+			slot := SlotResponse{
 				Id:   stand.Id,
 				Card: stand.Card,
-			})
+			}
+			if stand.Card != nil {
+				status := mining.CardStatus(*stand.Card, true, game)
+				slot.Mining = &status
+			}
+			slots = append(slots, slot)
 		}
 
 		w.Header().Set("Content-Type", "application/json")
 		if err := json.NewEncoder(w).Encode(slots); err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			writeError(w, r, apierror.ErrInternal.WithMessage("Failed to encode response").WithCause(err))
 		}
 	}
 }
 
-func GetGpuHandler(db *sqlx.DB) http.HandlerFunc {
+func GetGpuHandler(store database.Store, game config.Game) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userIdStr := chi.URLParam(r, "userId")
+		userIdStr, ok := authorizedChatId(w, r, "userId")
+		if !ok {
+			return
+		}
 
-		user, err := database.GetUser(db, userIdStr)
+		user, err := store.Users().GetUser(userIdStr)
 		if err != nil {
-			http.Error(w, "User not found", http.StatusNotFound)
+			writeError(w, r, err)
 			return
 		}
+		logging.AddAttrs(r.Context(), "user_id", user.Id)
 
-		cards, err := database.GetUserCards(db, user.Id)
+		cards, err := store.Cards().GetUserCards(user.Id)
 		if err != nil {
-			http.Error(w, "Failed to get GPUs", http.StatusInternalServerError)
+			writeError(w, r, apierror.ErrInternal.WithMessage("Failed to get GPUs").WithCause(err))
 			return
 		}
 
@@ -83,20 +180,24 @@ func GetGpuHandler(db *sqlx.DB) http.HandlerFunc {
 			cards = []database.Card{}
 		}
 
-		type CardWithIncome struct {
-			Card   database.Card `json:"card"`
-			Income float32       `json:"income"`
+		stands, err := store.Stands().GetUserCardStands(user.Id)
+		if err != nil {
+			writeError(w, r, apierror.ErrInternal.WithMessage("Failed to get slots").WithCause(err))
+			return
+		}
+		installed := make(map[int]bool, len(stands))
+		for _, stand := range stands {
+			if stand.CardId != nil {
+				installed[*stand.CardId] = true
+			}
 		}
 
 		var result []CardWithIncome
 		for _, c := range cards {
-			income := float32(c.Lvl) * 0.5
-			if c.Lvl == 0 {
-				income = 0.5
-			}
 			result = append(result, CardWithIncome{
 				Card:   c,
-				Income: income,
+				Income: mining.Income(c.Lvl, game),
+				Mining: mining.CardStatus(c, installed[c.Id], game),
 			})
 		}
 
@@ -105,18 +206,18 @@ func GetGpuHandler(db *sqlx.DB) http.HandlerFunc {
 	}
 }
 
-func GetGpuByIdHandler(db *sqlx.DB) http.HandlerFunc {
+func GetGpuByIdHandler(store database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		gpuIdStr := chi.URLParam(r, "gpuId")
 		gpuId, err := strconv.Atoi(gpuIdStr)
 		if err != nil {
-			http.Error(w, "Invalid gpuId", http.StatusBadRequest)
+			writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid gpuId"))
 			return
 		}
 
-		card, err := database.GetCardById(db, gpuId)
+		card, err := store.Cards().GetCardById(gpuId)
 		if err != nil {
-			http.Error(w, "GPU not found", http.StatusNotFound)
+			writeError(w, r, lookupError(err, apierror.ErrCardNotFound.WithMessage("GPU not found")))
 			return
 		}
 
@@ -125,117 +226,133 @@ func GetGpuByIdHandler(db *sqlx.DB) http.HandlerFunc {
 	}
 }
 
-func InstallGpuHandler(db *sqlx.DB) http.HandlerFunc {
+func InstallGpuHandler(store database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req InstallGpuRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 
-		user, err := database.GetUser(db, req.UserId)
-		if err != nil {
-			http.Error(w, "User not found", http.StatusNotFound)
+		chatId, ok := authorizedBodyChatId(w, r, req.UserId)
+		if !ok {
 			return
 		}
 
-		card, err := database.GetCardById(db, req.CardId)
-		if err != nil {
-			http.Error(w, "Card not found", http.StatusNotFound)
-			return
-		}
-		if card.UserId != user.Id {
-			json.NewEncoder(w).Encode(map[string]string{"status": "dontHaveGpu"})
-			return
-		}
+		err := store.WithTx(func(tx database.Store) error {
+			user, err := tx.Users().GetUserForUpdate(chatId)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
 
-		stand, err := database.GetCardStandById(db, req.StandId)
-		if err != nil {
-			http.Error(w, "Stand not found", http.StatusNotFound)
-			return
-		}
+			card, err := tx.Cards().GetCardByIdForUpdate(req.CardId)
+			if err != nil {
+				return lookupError(err, apierror.ErrCardNotFound)
+			}
+			if card.UserId != user.Id {
+				return apierror.ErrNotOwner
+			}
+			if err := checkNotInTrade(tx, card.Id); err != nil {
+				return err
+			}
 
-		if stand.UserId != user.Id {
-			json.NewEncoder(w).Encode(map[string]string{"status": "dontHaveStand"})
-			return
-		}
+			stand, err := tx.Stands().GetCardStandByIdForUpdate(req.StandId)
+			if err != nil {
+				return lookupError(err, apierror.ErrStandNotFound)
+			}
 
-		installedElsewhere, err := database.IsCardInstalledElsewhere(db, req.CardId)
-		if err != nil {
-			http.Error(w, "Database error", http.StatusInternalServerError)
-			return
-		}
+			if stand.UserId != user.Id {
+				return apierror.ErrStandNotOwned
+			}
 
-		if installedElsewhere {
-			json.NewEncoder(w).Encode(map[string]string{"status": "alreadyInstalledElsewhere"})
-			return
-		}
+			installedElsewhere, err := tx.Stands().IsCardInstalledElsewhere(req.CardId)
+			if err != nil {
+				return apierror.ErrInternal.WithMessage("Database error").WithCause(err)
+			}
 
-		if stand.CardId != nil {
-			json.NewEncoder(w).Encode(map[string]string{"status": "alreadyInstalled"})
-			return
-		}
+			if installedElsewhere {
+				return apierror.ErrInstalledElsewhere
+			}
 
-		newCoins := user.Coin + int(math.Floor(float64(card.Balance)))
+			if stand.CardId != nil {
+				return apierror.ErrAlreadyInstalled
+			}
 
-		if err := database.ResetCardBalance(db, card.Id); err != nil {
-			http.Error(w, "Failed to update card balance", http.StatusInternalServerError)
-			return
-		}
-		if err := database.UpdateUserCoins(db, user.Id, newCoins); err != nil {
-			http.Error(w, "Failed to update user coins", http.StatusInternalServerError)
-			return
-		}
-		if err := database.InsertCardIntoStand(db, stand.Id, card.Id); err != nil {
-			http.Error(w, "Failed to install card into stand", http.StatusInternalServerError)
+			if _, err := ledger.PayOutCard(tx, card, user.Id, ledger.ReasonGpuInstall); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to update user coins").WithCause(err)
+			}
+			if err := tx.Stands().InsertCardIntoStand(stand.Id, card.Id); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to install card into stand").WithCause(err)
+			}
+			if err := tx.Market().DelistCard(card.Id); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to delist card").WithCause(err)
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
-		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+		metrics.GpuInstalled()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(StatusResponse{Status: "success"})
 	}
 }
 
-func BuySlotHandler(db *sqlx.DB) http.HandlerFunc {
+func BuySlotHandler(store database.Store, game config.Game) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userIdStr := chi.URLParam(r, "userId")
-
-		user, err := database.GetUser(db, userIdStr)
-		if err != nil {
-			http.Error(w, "User not found", http.StatusNotFound)
+		userIdStr, ok := authorizedChatId(w, r, "userId")
+		if !ok {
 			return
 		}
 
-		stands, err := database.GetUserCardStands(db, user.Id)
-		if err != nil {
-			http.Error(w, "Failed to get slots", http.StatusInternalServerError)
-			return
-		}
+		var stand database.CardStand
+		err := store.WithTx(func(tx database.Store) error {
+			user, err := tx.Users().GetUserForUpdate(userIdStr)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
 
-		if len(stands) >= 9 {
-			json.NewEncoder(w).Encode(map[string]string{"status": "maxSlots"})
-			return
-		}
+			stands, err := tx.Stands().GetUserCardStands(user.Id)
+			if err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to get slots").WithCause(err)
+			}
 
-		const slotPrice = 2500000
-		if user.Balance < uint64(slotPrice) {
-			json.NewEncoder(w).Encode(map[string]string{"status": "noBalance"})
-			return
-		}
+			if len(stands) >= game.MaxSlots {
+				return apierror.ErrSlotLimit
+			}
 
-		newBalance := user.Balance - uint64(slotPrice)
-		err = database.UpdateUserBalance(db, user.Id, newBalance)
-		if err != nil {
-			http.Error(w, "Failed to update balance", http.StatusInternalServerError)
-			return
-		}
+			stand, err = tx.Stands().CreateCardStand(user.Id)
+			if err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to create slot").WithCause(err)
+			}
 
-		stand, err := database.CreateCardStand(db, user.Id)
+			err = ledger.Apply(tx, ledger.Posting{
+				UserId:      user.Id,
+				Currency:    database.CurrencyBalance,
+				Delta:       -game.SlotPrice,
+				Reason:      ledger.ReasonSlotPurchase,
+				ReferenceId: strconv.Itoa(stand.Id),
+			})
+			if err != nil {
+				if apiErr, ok := insufficientFunds(err); ok {
+					return apiErr.WithLegacyStatus("noBalance")
+				}
+				return apierror.ErrInternal.WithMessage("Failed to update balance").WithCause(err)
+			}
+			return nil
+		})
 		if err != nil {
-			http.Error(w, "Failed to create slot", http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
 
-		response := BuyGpuSlotRequest{
+		metrics.SlotBought()
+
+		response := BuySlotResponse{
 			Status: "success",
 			Slot:   stand,
 		}
@@ -245,64 +362,154 @@ func BuySlotHandler(db *sqlx.DB) http.HandlerFunc {
 	}
 }
 
-func FreezeGpuHandler(db *sqlx.DB) http.HandlerFunc {
+func FreezeGpuHandler(store database.Store, game config.Game) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req FreezeGpuRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 
-		user, err := database.GetUser(db, req.UserId)
-		if err != nil {
-			http.Error(w, "User not found", http.StatusNotFound)
+		chatId, ok := authorizedBodyChatId(w, r, req.UserId)
+		if !ok {
 			return
 		}
 
-		if user.Freeze <= 0 {
-			http.Error(w, "dontFreeze", http.StatusBadRequest)
-			return
-		}
+		var user database.User
+		var card database.Card
+		var newFuel int
+		err := store.WithTx(func(tx database.Store) error {
+			var err error
+			user, err = tx.Users().GetUserForUpdate(chatId)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+			err = ledger.Apply(tx, ledger.Posting{
+				UserId:      user.Id,
+				Currency:    database.CurrencyFreeze,
+				Delta:       -1,
+				Reason:      ledger.ReasonGpuFreeze,
+				ReferenceId: strconv.Itoa(req.CardId),
+			})
+			if err != nil {
+				if apiErr, ok := insufficientFunds(err); ok {
+					return apiErr.WithLegacyText(http.StatusBadRequest, "dontFreeze")
+				}
+				return apierror.ErrInternal.WithMessage("Failed to update user freeze").WithCause(err)
+			}
+
+			card, err = tx.Cards().GetCardByIdForUpdate(req.CardId)
+			if err != nil {
+				return lookupError(err, apierror.ErrCardNotFound.WithMessage("GPU not found"))
+			}
+
+			if card.UserId != user.Id {
+				return apierror.ErrNotOwner
+			}
+
+			if card.Fuel >= game.MaxFuel {
+				return apierror.ErrFuelFull
+			}
 
-		card, err := database.GetCardById(db, req.CardId)
+			newFuel = min(card.Fuel+game.FreezeFuel, game.MaxFuel)
+
+			if err := tx.Cards().UpdateCardFuel(card.Id, newFuel); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to update GPU fuel").WithCause(err)
+			}
+			return nil
+		})
 		if err != nil {
-			http.Error(w, "GPU not found", http.StatusNotFound)
+			writeError(w, r, err)
 			return
 		}
 
-		if card.UserId != user.Id {
-			json.NewEncoder(w).Encode(map[string]string{"status": "dontHaveGpu"})
-			return
+		metrics.FreezeUsed()
+
+		response := FreezeGpuResponse{
+			Status: "success",
+			Card: FrozenCard{
+				Id:     card.Id,
+				Fuel:   newFuel,
+				UserId: card.UserId,
+			},
+			User: FreezeBalance{
+				Id:     user.Id,
+				Freeze: user.Freeze - 1,
+			},
 		}
 
-		if card.Fuel >= 100 {
-			json.NewEncoder(w).Encode(map[string]string{"status": "alreadyFull"})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func WithdrawBitcoinHandler(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cardIdStr := chi.URLParam(r, "cardId")
+		userIdStr, ok := authorizedChatId(w, r, "userId")
+		if !ok {
 			return
 		}
 
-		newFuel := min(card.Fuel+50, 100)
-
-		if err := database.UpdateCardFuel(db, card.Id, newFuel); err != nil {
-			http.Error(w, "Failed to update GPU fuel", http.StatusInternalServerError)
+		cardId, err := strconv.Atoi(cardIdStr)
+		if err != nil {
+			writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid cardId"))
 			return
 		}
 
-		if err := database.DecrementUserFreeze(db, user.Id); err != nil {
-			http.Error(w, "Failed to update user freeze", http.StatusInternalServerError)
+		var user database.User
+		var cardBalance float32
+		var withdrawn int64
+		var newUserCoins int
+		err = store.WithTx(func(tx database.Store) error {
+			var err error
+			user, err = tx.Users().GetUserForUpdate(userIdStr)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+			card, err := tx.Cards().GetCardByIdForUpdate(cardId)
+			if err != nil {
+				return lookupError(err, apierror.ErrCardNotFound)
+			}
+
+			if card.UserId != user.Id {
+				return apierror.ErrNotOwner.WithLegacyStatus("dontHave")
+			}
+
+			// Only whole coins are paid out; the rest keeps mining.
+			if card.Balance < 1 {
+				return apierror.ErrNothingToWithdraw
+			}
+
+			withdrawn, err = ledger.PayOutCard(tx, card, user.Id, ledger.ReasonGpuWithdraw)
+			if err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to update user balance").WithCause(err)
+			}
+			cardBalance = card.Balance - float32(withdrawn)
+			newUserCoins = user.Coin + int(withdrawn)
+			return nil
+		})
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
-		response := map[string]interface{}{
-			"status": "success",
-			"card": map[string]interface{}{
-				"id":     card.Id,
-				"fuel":   newFuel,
-				"userId": card.UserId,
+		metrics.CoinsWithdrawn(withdrawn)
+
+		response := WithdrawResponse{
+			Status: "success",
+			User: CoinBalance{
+				Id:   user.Id,
+				Coin: newUserCoins,
 			},
-			"user": map[string]interface{}{
-				"id":     user.Id,
-				"freeze": user.Freeze - 1,
+			Card: WithdrawnCard{
+				Id:      cardId,
+				Balance: cardBalance,
 			},
+			Withdrawn: float32(withdrawn),
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -310,62 +517,255 @@ func FreezeGpuHandler(db *sqlx.DB) http.HandlerFunc {
 	}
 }
 
-func WithdrawBitcoinHandler(db *sqlx.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		cardIdStr := chi.URLParam(r, "cardId")
-		userIdStr := chi.URLParam(r, "userId")
+// upgradeCost is the price of taking a card from lvl to lvl+1, or false if
+// lvl is already the maximum.
+func upgradeCost(lvl int, game config.Game) (UpgradeCost, bool) {
+	lvl = max(lvl, 1)
+	if lvl >= game.MaxLevel || lvl > len(game.UpgradeCosts) {
+		return UpgradeCost{}, false
+	}
+	cost := game.UpgradeCosts[lvl-1]
+	return UpgradeCost{Currency: database.Currency(cost.Currency), Amount: cost.Amount}, true
+}
 
-		cardId, err := strconv.Atoi(cardIdStr)
-		if err != nil {
-			http.Error(w, "Invalid cardId", http.StatusBadRequest)
+func UpgradeGpuHandler(store database.Store, game config.Game) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chatId, ok := authorizedChatId(w, r, "userId")
+		if !ok {
 			return
 		}
 
-		user, err := database.GetUser(db, userIdStr)
+		cardId, err := strconv.Atoi(chi.URLParam(r, "cardId"))
 		if err != nil {
-			http.Error(w, "User not found", http.StatusNotFound)
+			writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid cardId"))
 			return
 		}
 
-		card, err := database.GetCardById(db, cardId)
+		var cost UpgradeCost
+		var newLvl int
+		err = store.WithTx(func(tx database.Store) error {
+			user, err := tx.Users().GetUserForUpdate(chatId)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+			card, err := tx.Cards().GetCardByIdForUpdate(cardId)
+			if err != nil {
+				return lookupError(err, apierror.ErrCardNotFound)
+			}
+
+			if card.UserId != user.Id {
+				return apierror.ErrNotOwner
+			}
+
+			if err := checkNotInTrade(tx, card.Id); err != nil {
+				return err
+			}
+
+			var ok bool
+			cost, ok = upgradeCost(card.Lvl, game)
+			if !ok {
+				return apierror.ErrMaxLevel
+			}
+
+			err = ledger.Apply(tx, ledger.Posting{
+				UserId:      user.Id,
+				Currency:    cost.Currency,
+				Delta:       -cost.Amount,
+				Reason:      ledger.ReasonGpuUpgrade,
+				ReferenceId: strconv.Itoa(card.Id),
+			})
+			if err != nil {
+				if apiErr, ok := insufficientFunds(err); ok {
+					return apiErr
+				}
+				return apierror.ErrInternal.WithMessage("Failed to charge for the upgrade").WithCause(err)
+			}
+
+			newLvl = max(card.Lvl, 1) + 1
+			if err := tx.Cards().UpdateCardLevel(card.Id, newLvl); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to update GPU level").WithCause(err)
+			}
+			if err := tx.Market().DelistCard(card.Id); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to delist card").WithCause(err)
+			}
+			return nil
+		})
 		if err != nil {
-			http.Error(w, "Card not found", http.StatusNotFound)
+			writeError(w, r, err)
 			return
 		}
 
-		if card.UserId != user.Id {
-			json.NewEncoder(w).Encode(map[string]string{"status": "dontHave"})
+		metrics.GpuUpgraded(newLvl)
+
+		response := UpgradeGpuResponse{
+			Status: "success",
+			Card:   UpgradedCard{Id: cardId, Lvl: newLvl},
+			Cost:   cost,
+			Income: mining.Income(newLvl, game),
+		}
+		if next, ok := upgradeCost(newLvl, game); ok {
+			response.NextCost = &next
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// fuseRoll returns a number in [0, 1); a fusion succeeds if it is below
+// game.FuseChance. Tests replace it to force the outcome.
+var fuseRoll = rand.Float64
+
+// FuseGpuHandler consumes at least game.FuseCards uninstalled cards of one
+// level. Their whole coins go to the user first. The first card is kept and,
+// if the roll succeeds, gains a level; the others are deleted either way and
+// the fractions of a coin left on them move to the kept card.
+func FuseGpuHandler(store database.Store, game config.Game) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req FuseGpuRequest
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 
-		cardBalance := card.Balance
-		if cardBalance <= 0 {
-			json.NewEncoder(w).Encode(map[string]string{"status": "noBalance"})
+		if len(req.CardIds) < game.FuseCards {
+			writeError(w, r, invalidFields(validate.FieldError{
+				Field: "cardIds", Rule: "min", Message: fmt.Sprintf("must have at least %d items", game.FuseCards),
+			}))
 			return
 		}
+		seen := make(map[int]bool, len(req.CardIds))
+		for _, id := range req.CardIds {
+			if seen[id] {
+				writeError(w, r, invalidFields(validate.FieldError{
+					Field: "cardIds", Rule: "unique", Message: "must not repeat a card",
+				}))
+				return
+			}
+			seen[id] = true
+		}
 
-		if err := database.ResetCardBalance(db, card.Id); err != nil {
-			http.Error(w, "Failed to reset card balance", http.StatusInternalServerError)
+		chatId, ok := authorizedBodyChatId(w, r, req.UserId)
+		if !ok {
 			return
 		}
 
-		newUserCoins := user.Coin + int(math.Floor(float64(cardBalance)))
-		if err := database.UpdateUserCoins(db, user.Id, newUserCoins); err != nil {
-			http.Error(w, "Failed to update user balance", http.StatusInternalServerError)
+		var card database.Card
+		var success bool
+		var withdrawn int64
+		consumed := req.CardIds[1:]
+		err := store.WithTx(func(tx database.Store) error {
+			user, err := tx.Users().GetUserForUpdate(chatId)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+			cards := make([]database.Card, 0, len(req.CardIds))
+			for _, id := range req.CardIds {
+				c, err := tx.Cards().GetCardByIdForUpdate(id)
+				if err != nil {
+					return lookupError(err, apierror.ErrCardNotFound.WithDetails(map[string]any{"cardId": id}))
+				}
+				if c.UserId != user.Id {
+					return apierror.ErrNotOwner.WithDetails(map[string]any{"cardId": id})
+				}
+				installed, err := tx.Stands().IsCardInstalledElsewhere(id)
+				if err != nil {
+					return apierror.ErrInternal.WithMessage("Failed to check card stand").WithCause(err)
+				}
+				if installed {
+					return apierror.ErrCardInstalled.WithDetails(map[string]any{"cardId": id})
+				}
+				if err := checkNotInTrade(tx, id); err != nil {
+					return err
+				}
+				if len(cards) > 0 && max(c.Lvl, 1) != max(cards[0].Lvl, 1) {
+					return apierror.ErrLevelMismatch
+				}
+				cards = append(cards, c)
+			}
+
+			lvl := max(cards[0].Lvl, 1)
+			if lvl >= game.MaxLevel {
+				return apierror.ErrMaxLevel
+			}
+
+			kept := cards[0]
+			for i, c := range cards {
+				coins, err := ledger.PayOutCard(tx, c, user.Id, ledger.ReasonGpuFuse)
+				if err != nil {
+					return apierror.ErrInternal.WithMessage("Failed to update user balance").WithCause(err)
+				}
+				withdrawn += coins
+				if i == 0 {
+					kept.Balance -= float32(coins)
+				} else {
+					kept.Balance += c.Balance - float32(coins)
+				}
+			}
+			if err := tx.Cards().UpdateCardMining(kept.Id, kept.Balance, kept.Fuel); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to update GPU balance").WithCause(err)
+			}
+
+			for _, c := range cards {
+				if err := tx.Market().DelistCard(c.Id); err != nil {
+					return apierror.ErrInternal.WithMessage("Failed to delist card").WithCause(err)
+				}
+			}
+			for _, id := range consumed {
+				if err := tx.Cards().DeleteCard(id); err != nil {
+					return apierror.ErrInternal.WithMessage("Failed to delete card").WithCause(err)
+				}
+			}
+
+			success = fuseRoll() < game.FuseChance
+			if success {
+				if err := tx.Cards().UpdateCardLevel(cards[0].Id, lvl+1); err != nil {
+					return apierror.ErrInternal.WithMessage("Failed to update GPU level").WithCause(err)
+				}
+			}
+
+			ids := make([]string, len(consumed))
+			for i, id := range consumed {
+				ids[i] = strconv.Itoa(id)
+			}
+			err = tx.Cards().InsertCardFusion(database.CardFusion{
+				UserId:          user.Id,
+				CardId:          cards[0].Id,
+				Lvl:             lvl,
+				Success:         success,
+				ConsumedCardIds: strings.Join(ids, ","),
+			})
+			if err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to record fusion").WithCause(err)
+			}
+
+			card, err = tx.Cards().GetCardById(cards[0].Id)
+			if err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to load fused card").WithCause(err)
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
-		response := map[string]interface{}{
-			"status": "success",
-			"user": map[string]interface{}{
-				"id":   user.Id,
-				"coin": newUserCoins,
-			},
-			"card": map[string]interface{}{
-				"id":      card.Id,
-				"balance": 0,
-			},
-			"withdrawn": cardBalance,
+		metrics.GpuFused(success)
+		metrics.CoinsWithdrawn(withdrawn)
+
+		status := "failed"
+		if success {
+			status = "success"
+		}
+		response := FuseGpuResponse{
+			Status:    status,
+			Card:      card,
+			Income:    mining.Income(card.Lvl, game),
+			Consumed:  consumed,
+			Withdrawn: withdrawn,
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -373,50 +773,106 @@ func WithdrawBitcoinHandler(db *sqlx.DB) http.HandlerFunc {
 	}
 }
 
-func PullGpuHandler(db *sqlx.DB) http.HandlerFunc {
+func PullGpuHandler(store database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		gpuIdStr := chi.URLParam(r, "gpuId")
-		userId := chi.URLParam(r, "userId")
-
-		gpuId, err := strconv.Atoi(gpuIdStr)
-		if err != nil {
-			http.Error(w, "Invalid gpuId", http.StatusBadRequest)
+		userId, ok := authorizedChatId(w, r, "userId")
+		if !ok {
 			return
 		}
 
-		user, err := database.GetUser(db, userId)
+		gpuId, err := strconv.Atoi(gpuIdStr)
 		if err != nil {
-			http.Error(w, "User not found", http.StatusNotFound)
+			writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid gpuId"))
 			return
 		}
 
-		card, err := database.GetCardById(db, gpuId)
+		err = store.WithTx(func(tx database.Store) error {
+			user, err := tx.Users().GetUserForUpdate(userId)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+			card, err := tx.Cards().GetCardByIdForUpdate(gpuId)
+			if err != nil {
+				return lookupError(err, apierror.ErrCardNotFound)
+			}
+
+			if card.UserId != user.Id {
+				return apierror.ErrNotOwner
+			}
+
+			stand, err := tx.Stands().GetStandByCardIdForUpdate(card.Id)
+			if err != nil {
+				return lookupError(err, apierror.ErrNotInstalled)
+			}
+
+			if err := tx.Stands().RemoveCardFromStand(stand.Id); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to remove card from stand").WithCause(err)
+			}
+			return nil
+		})
 		if err != nil {
-			http.Error(w, "Card not found", http.StatusNotFound)
+			writeError(w, r, err)
 			return
 		}
 
-		if card.UserId != user.Id {
-			json.NewEncoder(w).Encode(map[string]string{"status": "dontHaveGpu"})
+		metrics.GpuPulled()
+
+		response := StatusResponse{Status: "success"}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func RemoveStandCardHandler(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chatId, ok := authorizedChatId(w, r, "userId")
+		if !ok {
 			return
 		}
 
-		stand, err := database.GetStandByCardId(db, card.Id)
+		standId, err := strconv.Atoi(chi.URLParam(r, "standId"))
 		if err != nil {
-			json.NewEncoder(w).Encode(map[string]string{"status": "dontHaveStand"})
+			writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid standId"))
 			return
 		}
 
-		if err := database.RemoveCardFromStand(db, stand.Id); err != nil {
-			http.Error(w, "Failed to remove card from stand", http.StatusInternalServerError)
+		err = store.WithTx(func(tx database.Store) error {
+			user, err := tx.Users().GetUserForUpdate(chatId)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+			stand, err := tx.Stands().GetCardStandByIdForUpdate(standId)
+			if err != nil {
+				return lookupError(err, apierror.ErrStandNotFound)
+			}
+
+			if stand.UserId != user.Id {
+				return apierror.ErrStandNotOwned
+			}
+
+			if stand.CardId == nil {
+				return apierror.ErrStandEmpty
+			}
+
+			if err := tx.Stands().RemoveCardFromStand(stand.Id); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to remove card from stand").WithCause(err)
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, r, err)
 			return
 		}
 
-		response := map[string]string{
-			"status": "success",
-		}
+		metrics.GpuPulled()
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+		json.NewEncoder(w).Encode(StatusResponse{Status: "success"})
 	}
 }
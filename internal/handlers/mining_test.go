@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/ledger"
+	"github.com/go-chi/chi/v5"
+)
+
+// TestWithdrawConcurrent checks the handler's side of a withdraw race. The
+// memory store runs transactions one at a time; the ConcurrentWithdraw case
+// of the database store suite checks the row locking against MySQL.
+func TestWithdrawConcurrent(t *testing.T) {
+	store := database.NewMemoryStore()
+	user := store.PutUser(database.User{ChatId: "42", Coin: 100})
+	card := store.PutCard(database.Card{UserId: user.Id, Lvl: 1, Balance: 12.7})
+
+	h := newRouter(t, func(r chi.Router) {
+		r.Post("/mining/cards/{cardId}/withdraw", WithdrawBitcoinHandler(store))
+	})
+
+	const workers = 50
+	codes := make(chan int, workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := do(h, http.MethodPost, fmt.Sprintf("/mining/cards/%d/withdraw", card.Id), "42", "")
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	var ok, conflict int
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			ok++
+		case http.StatusConflict:
+			conflict++
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if ok != 1 || conflict != workers-1 {
+		t.Fatalf("got %d successes and %d conflicts, want 1 and %d", ok, conflict, workers-1)
+	}
+
+	got, err := store.Users().GetUser("42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Coin != 112 {
+		t.Errorf("coin = %d, want 112", got.Coin)
+	}
+
+	left, err := store.Cards().GetCardById(card.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(float64(left.Balance)-0.7) > 1e-4 {
+		t.Errorf("card balance = %v, want the 0.7 left over", left.Balance)
+	}
+
+	entries, err := store.Ledger().GetUserLedger(user.Id, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var withdrawals int
+	for _, e := range entries {
+		if e.Reason == string(ledger.ReasonGpuWithdraw) {
+			withdrawals++
+		}
+	}
+	if withdrawals != 1 {
+		t.Errorf("got %d withdraw ledger entries, want 1", withdrawals)
+	}
+}
+
+func TestUpgradeGpu(t *testing.T) {
+	game := config.Game{
+		MaxLevel:       4,
+		IncomePerLevel: 0.5,
+		UpgradeCosts: []config.UpgradeCost{
+			{Currency: "coin", Amount: 100},
+			{Currency: "balance", Amount: 500},
+			{Currency: "gems", Amount: 5},
+		},
+	}
+
+	type testCase struct {
+		name       string
+		lvl        int
+		funds      int64
+		wantStatus int
+		wantCode   string
+	}
+	var tests []testCase
+	for i, cost := range game.UpgradeCosts {
+		lvl := i + 1
+		tests = append(tests,
+			testCase{name: fmt.Sprintf("level %d with %s", lvl, cost), lvl: lvl, funds: cost.Amount, wantStatus: http.StatusOK},
+			testCase{name: fmt.Sprintf("level %d short of %s", lvl, cost), lvl: lvl, funds: cost.Amount - 1,
+				wantStatus: http.StatusUnprocessableEntity, wantCode: "insufficient_funds"},
+		)
+	}
+	tests = append(tests,
+		testCase{name: "level 0 counts as level 1", lvl: 0, funds: 100, wantStatus: http.StatusOK},
+		testCase{name: "max level", lvl: game.MaxLevel, funds: 1000, wantStatus: http.StatusConflict, wantCode: "max_level"},
+	)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost, ok := upgradeCost(tt.lvl, game)
+			if !ok {
+				cost = UpgradeCost{Currency: database.CurrencyCoin}
+			}
+
+			store := database.NewMemoryStore()
+			user := database.User{ChatId: "42"}
+			switch cost.Currency {
+			case database.CurrencyCoin:
+				user.Coin = int(tt.funds)
+			case database.CurrencyBalance:
+				user.Balance = uint64(tt.funds)
+			case database.CurrencyGems:
+				user.Gems = int(tt.funds)
+			}
+			user = store.PutUser(user)
+			card := store.PutCard(database.Card{UserId: user.Id, Lvl: tt.lvl})
+
+			h := newRouter(t, func(r chi.Router) {
+				r.Post("/mining/cards/{cardId}/upgrade", UpgradeGpuHandler(store, game))
+			})
+			w := do(h, http.MethodPost, fmt.Sprintf("/mining/cards/%d/upgrade", card.Id), "42", "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			got, err := store.Cards().GetCardById(card.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			left, err := store.Users().GetUserCurrency(user.Id, cost.Currency)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if code := errorCode(t, w); code != tt.wantCode {
+					t.Errorf("code = %q, want %q", code, tt.wantCode)
+				}
+				if got.Lvl != tt.lvl || left != tt.funds {
+					t.Errorf("failed upgrade changed lvl to %d and %s to %d", got.Lvl, cost.Currency, left)
+				}
+				return
+			}
+
+			var resp UpgradeGpuResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			wantLvl := max(tt.lvl, 1) + 1
+			if resp.Card.Lvl != wantLvl || got.Lvl != wantLvl {
+				t.Errorf("lvl = %d (stored %d), want %d", resp.Card.Lvl, got.Lvl, wantLvl)
+			}
+			if resp.Cost != cost {
+				t.Errorf("cost = %+v, want %+v", resp.Cost, cost)
+			}
+			if left != 0 {
+				t.Errorf("%s left = %d, want 0", cost.Currency, left)
+			}
+			if next, ok := upgradeCost(wantLvl, game); ok != (resp.NextCost != nil) || (ok && *resp.NextCost != next) {
+				t.Errorf("nextCost = %v, want %v", resp.NextCost, next)
+			}
+		})
+	}
+}
+
+func TestFuseGpu(t *testing.T) {
+	game := config.Game{MaxLevel: 5, FuseCards: 2, FuseChance: 0.5, IncomePerLevel: 0.5}
+
+	tests := []struct {
+		name string
+		roll float64
+		// lvls and balances describe the fused cards; the first is kept.
+		lvls       []int
+		balances   []float32
+		installed  bool
+		wantStatus int
+		wantCode   string
+		wantResult string
+	}{
+		{name: "successful roll", roll: 0.1, lvls: []int{2, 2, 2}, balances: []float32{1.25, 2.5, 0.75},
+			wantStatus: http.StatusOK, wantResult: "success"},
+		{name: "failed roll", roll: 0.9, lvls: []int{2, 2, 2}, balances: []float32{1.25, 2.5, 0.75},
+			wantStatus: http.StatusOK, wantResult: "failed"},
+		{name: "too few cards", lvls: []int{2},
+			wantStatus: http.StatusBadRequest, wantCode: "validation_failed"},
+		{name: "level mismatch", lvls: []int{2, 3},
+			wantStatus: http.StatusConflict, wantCode: "level_mismatch"},
+		{name: "installed card", lvls: []int{2, 2}, installed: true,
+			wantStatus: http.StatusConflict, wantCode: "card_installed"},
+		{name: "max level", lvls: []int{5, 5},
+			wantStatus: http.StatusConflict, wantCode: "max_level"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roll := fuseRoll
+			fuseRoll = func() float64 { return tt.roll }
+			t.Cleanup(func() { fuseRoll = roll })
+
+			store := database.NewMemoryStore()
+			user := store.PutUser(database.User{ChatId: "42"})
+			var ids []string
+			var cards []database.Card
+			for i, lvl := range tt.lvls {
+				card := database.Card{UserId: user.Id, Lvl: lvl, Fuel: 50}
+				if i < len(tt.balances) {
+					card.Balance = tt.balances[i]
+				}
+				card = store.PutCard(card)
+				cards = append(cards, card)
+				ids = append(ids, strconv.Itoa(card.Id))
+			}
+			if tt.installed {
+				store.PutStand(database.CardStand{UserId: user.Id, CardId: &cards[len(cards)-1].Id})
+			}
+
+			h := newRouter(t, func(r chi.Router) {
+				r.Post("/mining/cards/fuse", FuseGpuHandler(store, game))
+			})
+			body := fmt.Sprintf(`{"userId":"42","cardIds":[%s]}`, strings.Join(ids, ","))
+			w := do(h, http.MethodPost, "/mining/cards/fuse", "42", body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if code := errorCode(t, w); code != tt.wantCode {
+					t.Errorf("code = %q, want %q", code, tt.wantCode)
+				}
+				for _, c := range cards {
+					if got, err := store.Cards().GetCardById(c.Id); err != nil || got != c {
+						t.Errorf("rejected fusion changed card %d: %+v, %v", c.Id, got, err)
+					}
+				}
+				if fusions := store.Fusions(); len(fusions) != 0 {
+					t.Errorf("rejected fusion was recorded: %+v", fusions)
+				}
+				return
+			}
+
+			var resp FuseGpuResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			wantLvl := tt.lvls[0]
+			if tt.wantResult == "success" {
+				wantLvl++
+			}
+			if resp.Status != tt.wantResult || resp.Card.Lvl != wantLvl || resp.Withdrawn != 3 {
+				t.Errorf("got status %q, lvl %d, withdrawn %d, want %q, %d, 3", resp.Status, resp.Card.Lvl, resp.Withdrawn, tt.wantResult, wantLvl)
+			}
+
+			// The whole coins are paid out; the fractions of the consumed
+			// cards join the kept card's own.
+			if coin, _ := store.Users().GetUserCurrency(user.Id, database.CurrencyCoin); coin != 3 {
+				t.Errorf("coin = %d, want 3", coin)
+			}
+			kept, err := store.Cards().GetCardById(cards[0].Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept.Balance != 1.5 || kept.Lvl != wantLvl || kept.Fuel != 50 {
+				t.Errorf("kept card = %+v, want balance 1.5, lvl %d, fuel 50", kept, wantLvl)
+			}
+			for _, c := range cards[1:] {
+				if _, err := store.Cards().GetCardById(c.Id); !errors.Is(err, sql.ErrNoRows) {
+					t.Errorf("consumed card %d: got %v, want it deleted", c.Id, err)
+				}
+			}
+
+			fusions := store.Fusions()
+			if len(fusions) != 1 {
+				t.Fatalf("got %d fusion records, want 1", len(fusions))
+			}
+			want := database.CardFusion{
+				Id:              fusions[0].Id,
+				UserId:          user.Id,
+				CardId:          cards[0].Id,
+				Lvl:             tt.lvls[0],
+				Success:         tt.wantResult == "success",
+				ConsumedCardIds: strings.Join(ids[1:], ","),
+				CreatedAt:       fusions[0].CreatedAt,
+			}
+			if fusions[0] != want {
+				t.Errorf("fusion record = %+v, want %+v", fusions[0], want)
+			}
+		})
+	}
+}
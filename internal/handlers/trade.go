@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/handlers/apierror"
+	"example.com/myapp/internal/logging"
+	"example.com/myapp/internal/metrics"
+	"example.com/myapp/internal/trade"
+	"example.com/myapp/internal/validate"
+	"github.com/go-chi/chi/v5"
+)
+
+// TradeBundle is one side of a trade: cards and amounts of currency.
+type TradeBundle struct {
+	CardIds []int `json:"cardIds" validate:"max=10"`
+	Coin    int64 `json:"coin" validate:"min=0"`
+	Gems    int64 `json:"gems" validate:"min=0"`
+	Balance int64 `json:"balance" validate:"min=0"`
+}
+
+type CreateTradeRequest struct {
+	UserId   string      `json:"userId" validate:"required"`
+	ToUserId string      `json:"toUserId" validate:"required"`
+	Offer    TradeBundle `json:"offer"`
+	Request  TradeBundle `json:"request"`
+}
+
+type TradeResponse struct {
+	Id         int64       `json:"id"`
+	FromUserId int         `json:"fromUserId"`
+	ToUserId   int         `json:"toUserId"`
+	Status     string      `json:"status"`
+	Offer      TradeBundle `json:"offer"`
+	Request    TradeBundle `json:"request"`
+	ExpiresAt  time.Time   `json:"expiresAt"`
+	CreatedAt  time.Time   `json:"createdAt"`
+}
+
+type TradesResponse struct {
+	Trades []TradeResponse `json:"trades"`
+}
+
+func (b TradeBundle) items(side database.TradeSide) []database.TradeItem {
+	var items []database.TradeItem
+	for _, id := range b.CardIds {
+		items = append(items, database.TradeItem{Side: side, CardId: &id})
+	}
+	amounts := []struct {
+		currency database.Currency
+		amount   int64
+	}{
+		{database.CurrencyCoin, b.Coin},
+		{database.CurrencyGems, b.Gems},
+		{database.CurrencyBalance, b.Balance},
+	}
+	for _, a := range amounts {
+		if a.amount > 0 {
+			currency := a.currency
+			items = append(items, database.TradeItem{Side: side, Currency: &currency, Amount: a.amount})
+		}
+	}
+	return items
+}
+
+func (b TradeBundle) empty() bool {
+	return len(b.CardIds) == 0 && b.Coin == 0 && b.Gems == 0 && b.Balance == 0
+}
+
+func tradeBundle(t database.TradeOffer, side database.TradeSide) TradeBundle {
+	b := TradeBundle{CardIds: []int{}}
+	for _, item := range t.Items {
+		if item.Side != side {
+			continue
+		}
+		switch {
+		case item.CardId != nil:
+			b.CardIds = append(b.CardIds, *item.CardId)
+		case item.Currency == nil:
+		case *item.Currency == database.CurrencyCoin:
+			b.Coin += item.Amount
+		case *item.Currency == database.CurrencyGems:
+			b.Gems += item.Amount
+		case *item.Currency == database.CurrencyBalance:
+			b.Balance += item.Amount
+		}
+	}
+	return b
+}
+
+func tradeResponse(t database.TradeOffer) TradeResponse {
+	return TradeResponse{
+		Id:         t.Id,
+		FromUserId: t.FromUserId,
+		ToUserId:   t.ToUserId,
+		Status:     string(t.Status),
+		Offer:      tradeBundle(t, database.TradeOffered),
+		Request:    tradeBundle(t, database.TradeRequested),
+		ExpiresAt:  t.ExpiresAt,
+		CreatedAt:  t.CreatedAt,
+	}
+}
+
+// checkNotInTrade rejects changes to a card that a pending trade offers.
+func checkNotInTrade(tx database.Store, cardId int) error {
+	held, err := tx.Trades().IsCardInTrade(cardId)
+	if err != nil {
+		return apierror.ErrInternal.WithMessage("Failed to check trades").WithCause(err)
+	}
+	if held {
+		return apierror.ErrCardInTrade.WithDetails(map[string]any{"cardId": cardId})
+	}
+	return nil
+}
+
+// checkTradeCardIds rejects a card listed twice on one side of a trade or
+// on both sides.
+func checkTradeCardIds(offered, requested []int) error {
+	sides := []struct {
+		field string
+		ids   []int
+	}{
+		{"offer.cardIds", offered},
+		{"request.cardIds", requested},
+	}
+	for _, side := range sides {
+		seen := make(map[int]bool, len(side.ids))
+		for _, id := range side.ids {
+			if seen[id] {
+				return invalidFields(validate.FieldError{
+					Field: side.field, Rule: "unique", Message: "must not repeat a card",
+				})
+			}
+			seen[id] = true
+		}
+	}
+
+	for _, id := range requested {
+		if slices.Contains(offered, id) {
+			return invalidFields(validate.FieldError{
+				Field: "request.cardIds", Rule: "excluded", Message: "must not include a card from offer.cardIds",
+			})
+		}
+	}
+	return nil
+}
+
+// heldTradeCard locks a card that is part of a trade and checks that owner
+// can still hand it over: it must be theirs and uninstalled.
+func heldTradeCard(tx database.Store, cardId, owner int) error {
+	card, err := tx.Cards().GetCardByIdForUpdate(cardId)
+	if err != nil {
+		return lookupError(err, apierror.ErrCardNotFound.WithDetails(map[string]any{"cardId": cardId}))
+	}
+	if card.UserId != owner {
+		return apierror.ErrNotOwner.WithDetails(map[string]any{"cardId": cardId})
+	}
+
+	installed, err := tx.Stands().IsCardInstalledElsewhere(cardId)
+	if err != nil {
+		return apierror.ErrInternal.WithMessage("Failed to check card stand").WithCause(err)
+	}
+	if installed {
+		return apierror.ErrCardInstalled.WithDetails(map[string]any{"cardId": cardId})
+	}
+	return nil
+}
+
+// tradeCard is heldTradeCard for a card not yet in a trade: it must not be
+// offered elsewhere either.
+func tradeCard(tx database.Store, cardId, owner int) error {
+	if err := heldTradeCard(tx, cardId, owner); err != nil {
+		return err
+	}
+	return checkNotInTrade(tx, cardId)
+}
+
+func CreateTradeHandler(store database.Store, game config.Game) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CreateTradeRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+
+		if req.Offer.empty() && req.Request.empty() {
+			writeError(w, r, invalidFields(validate.FieldError{
+				Field: "offer", Rule: "required", Message: "offer or request must not be empty",
+			}))
+			return
+		}
+		if err := checkTradeCardIds(req.Offer.CardIds, req.Request.CardIds); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		chatId, ok := authorizedBodyChatId(w, r, req.UserId)
+		if !ok {
+			return
+		}
+		if req.ToUserId == chatId {
+			writeError(w, r, apierror.ErrBadRequest.WithMessage("Cannot trade with yourself"))
+			return
+		}
+
+		var t database.TradeOffer
+		err := store.WithTx(func(tx database.Store) error {
+			from, err := tx.Users().GetUserForUpdate(chatId)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", from.Id)
+
+			to, err := tx.Users().GetUser(req.ToUserId)
+			if err != nil {
+				return err
+			}
+
+			for _, id := range req.Offer.CardIds {
+				if err := tradeCard(tx, id, from.Id); err != nil {
+					return err
+				}
+				listed, err := tx.Market().IsCardListed(id)
+				if err != nil {
+					return apierror.ErrInternal.WithMessage("Failed to check listings").WithCause(err)
+				}
+				if listed {
+					return apierror.ErrAlreadyListed.WithDetails(map[string]any{"cardId": id})
+				}
+			}
+			for _, id := range req.Request.CardIds {
+				card, err := tx.Cards().GetCardById(id)
+				if err != nil {
+					return lookupError(err, apierror.ErrCardNotFound.WithDetails(map[string]any{"cardId": id}))
+				}
+				if card.UserId != to.Id {
+					return apierror.ErrNotOwner.WithDetails(map[string]any{"cardId": id})
+				}
+			}
+
+			t, err = tx.Trades().CreateTrade(database.TradeOffer{
+				FromUserId: from.Id,
+				ToUserId:   to.Id,
+				ExpiresAt:  time.Now().Add(game.TradeTTL),
+				Items:      append(req.Offer.items(database.TradeOffered), req.Request.items(database.TradeRequested)...),
+			})
+			if err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to create trade").WithCause(err)
+			}
+
+			if err := trade.Escrow(tx, t); err != nil {
+				if apiErr, ok := insufficientFunds(err); ok {
+					return apiErr
+				}
+				return apierror.ErrInternal.WithMessage("Failed to escrow trade").WithCause(err)
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		metrics.Trade("created")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tradeResponse(t))
+	}
+}
+
+func GetTradesHandler(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chatId, ok := authorizedChatId(w, r, "userId")
+		if !ok {
+			return
+		}
+
+		user, err := store.Users().GetUser(chatId)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+		trades, err := store.Trades().GetUserPendingTrades(user.Id)
+		if err != nil {
+			writeError(w, r, apierror.ErrInternal.WithMessage("Failed to get trades").WithCause(err))
+			return
+		}
+
+		resp := TradesResponse{Trades: make([]TradeResponse, 0, len(trades))}
+		for _, t := range trades {
+			resp.Trades = append(resp.Trades, tradeResponse(t))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// pendingTrade loads and locks the trade in the URL for user. It must be
+// pending, and the recipient or sender, as given by recipient, must be user.
+func pendingTrade(tx database.Store, r *http.Request, user database.User, recipient bool) (database.TradeOffer, error) {
+	tradeId, err := strconv.ParseInt(chi.URLParam(r, "tradeId"), 10, 64)
+	if err != nil {
+		return database.TradeOffer{}, apierror.ErrBadRequest.WithMessage("Invalid tradeId")
+	}
+
+	t, err := tx.Trades().GetTradeForUpdate(tradeId)
+	if err != nil {
+		return database.TradeOffer{}, lookupError(err, apierror.ErrTradeNotFound)
+	}
+	if (recipient && t.ToUserId != user.Id) || (!recipient && t.FromUserId != user.Id) {
+		return database.TradeOffer{}, apierror.ErrTradeNotOwned
+	}
+	if t.Status != database.TradePending {
+		return database.TradeOffer{}, apierror.ErrTradeNotPending
+	}
+	return t, nil
+}
+
+// AcceptTradeHandler settles a trade for its recipient in one transaction:
+// the escrow and offered cards go to the recipient, and what the sender
+// asked for goes to the sender.
+func AcceptTradeHandler(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chatId, ok := authorizedChatId(w, r, "userId")
+		if !ok {
+			return
+		}
+
+		var t database.TradeOffer
+		err := store.WithTx(func(tx database.Store) error {
+			// The user row is not locked here: the trade row serialises
+			// work on the trade, and Settle updates users in id order.
+			user, err := tx.Users().GetUser(chatId)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+			t, err = pendingTrade(tx, r, user, true)
+			if err != nil {
+				return err
+			}
+			if !time.Now().Before(t.ExpiresAt) {
+				return apierror.ErrTradeExpired
+			}
+
+			// The offered cards are held by this trade; the requested ones
+			// must not be held by another.
+			for _, item := range t.Items {
+				if item.CardId == nil {
+					continue
+				}
+				check, owner := heldTradeCard, t.FromUserId
+				if item.Side == database.TradeRequested {
+					check, owner = tradeCard, t.ToUserId
+				}
+				if err := check(tx, *item.CardId, owner); err != nil {
+					return err
+				}
+			}
+
+			if err := trade.Settle(tx, t); err != nil {
+				if apiErr, ok := insufficientFunds(err); ok {
+					return apiErr
+				}
+				return apierror.ErrInternal.WithMessage("Failed to settle trade").WithCause(err)
+			}
+			t.Status = database.TradeAccepted
+			return nil
+		})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		metrics.Trade(string(t.Status))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tradeResponse(t))
+	}
+}
+
+// closeTradeHandler refunds a pending trade and closes it with status. The
+// recipient rejects a trade; the sender cancels it.
+func closeTradeHandler(store database.Store, status database.TradeStatus, recipient bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chatId, ok := authorizedChatId(w, r, "userId")
+		if !ok {
+			return
+		}
+
+		var t database.TradeOffer
+		err := store.WithTx(func(tx database.Store) error {
+			user, err := tx.Users().GetUser(chatId)
+			if err != nil {
+				return err
+			}
+			logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+			t, err = pendingTrade(tx, r, user, recipient)
+			if err != nil {
+				return err
+			}
+
+			if err := trade.Release(tx, t, status); err != nil {
+				return apierror.ErrInternal.WithMessage("Failed to release trade").WithCause(err)
+			}
+			t.Status = status
+			return nil
+		})
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		metrics.Trade(string(status))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tradeResponse(t))
+	}
+}
+
+func RejectTradeHandler(store database.Store) http.HandlerFunc {
+	return closeTradeHandler(store, database.TradeRejected, true)
+}
+
+func CancelTradeHandler(store database.Store) http.HandlerFunc {
+	return closeTradeHandler(store, database.TradeCancelled, false)
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/ledger"
+	"example.com/myapp/internal/trade"
+	"github.com/go-chi/chi/v5"
+)
+
+// tradeFixture is a trade from alice ("42") to bob ("43"): alice offers
+// card a and 30 coin for card b and 20 gems. Both cards carry a balance.
+type tradeFixture struct {
+	store      *database.MemoryStore
+	h          http.Handler
+	alice, bob database.User
+	a, b       database.Card
+	trade      TradeResponse
+}
+
+const tradeBody = `{"userId":"42","toUserId":"43","offer":{"cardIds":[%d],"coin":30},"request":{"cardIds":[%d],"gems":20}}`
+
+func newTradeFixture(t *testing.T, ttl time.Duration) *tradeFixture {
+	t.Helper()
+	f := &tradeFixture{store: database.NewMemoryStore()}
+	f.alice = f.store.PutUser(database.User{ChatId: "42", Coin: 100})
+	f.bob = f.store.PutUser(database.User{ChatId: "43", Coin: 50, Gems: 20})
+	f.a = f.store.PutCard(database.Card{UserId: f.alice.Id, Lvl: 1, Balance: 1.5})
+	f.b = f.store.PutCard(database.Card{UserId: f.bob.Id, Lvl: 2, Balance: 2.25})
+
+	game := config.Game{TradeTTL: ttl}
+	f.h = newRouter(t, func(r chi.Router) {
+		r.Post("/trades", CreateTradeHandler(f.store, game))
+		r.Post("/trades/{tradeId}/accept", AcceptTradeHandler(f.store))
+		r.Post("/trades/{tradeId}/reject", RejectTradeHandler(f.store))
+		r.Delete("/trades/{tradeId}", CancelTradeHandler(f.store))
+	})
+
+	w := do(f.h, http.MethodPost, "/trades", "42", fmt.Sprintf(tradeBody, f.a.Id, f.b.Id))
+	if w.Code != http.StatusOK {
+		t.Fatalf("create trade: status %d: %s", w.Code, w.Body)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &f.trade); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f *tradeFixture) coin(t *testing.T, user database.User) int64 {
+	t.Helper()
+	return f.currency(t, user, database.CurrencyCoin)
+}
+
+func (f *tradeFixture) gems(t *testing.T, user database.User) int64 {
+	t.Helper()
+	return f.currency(t, user, database.CurrencyGems)
+}
+
+func (f *tradeFixture) currency(t *testing.T, user database.User, currency database.Currency) int64 {
+	t.Helper()
+	amount, err := f.store.Users().GetUserCurrency(user.Id, currency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amount
+}
+
+func (f *tradeFixture) card(t *testing.T, id int) database.Card {
+	t.Helper()
+	card, err := f.store.Cards().GetCardById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return card
+}
+
+func (f *tradeFixture) status(t *testing.T) database.TradeStatus {
+	t.Helper()
+	got, err := f.store.Trades().GetTradeForUpdate(f.trade.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got.Status
+}
+
+// checkUnsettled checks that only the escrow has moved.
+func (f *tradeFixture) checkUnsettled(t *testing.T) {
+	t.Helper()
+	if coin := f.coin(t, f.alice); coin != 70 {
+		t.Errorf("alice coin = %d, want 70", coin)
+	}
+	if coin, gems := f.coin(t, f.bob), f.gems(t, f.bob); coin != 50 || gems != 20 {
+		t.Errorf("bob has %d coin and %d gems, want 50 and 20", coin, gems)
+	}
+	if a := f.card(t, f.a.Id); a.UserId != f.alice.Id || a.Balance != 1.5 {
+		t.Errorf("card a = %+v, want alice's with 1.5", a)
+	}
+	if b := f.card(t, f.b.Id); b.UserId != f.bob.Id || b.Balance != 2.25 {
+		t.Errorf("card b = %+v, want bob's with 2.25", b)
+	}
+	if status := f.status(t); status != database.TradePending {
+		t.Errorf("trade status = %q, want pending", status)
+	}
+}
+
+func TestCreateTradeEscrowsOffer(t *testing.T) {
+	f := newTradeFixture(t, time.Hour)
+	f.checkUnsettled(t)
+
+	if held, err := f.store.Trades().IsCardInTrade(f.a.Id); err != nil || !held {
+		t.Errorf("offered card held = %v, err %v, want held", held, err)
+	}
+	var escrow int64
+	for _, e := range f.store.LedgerEntries() {
+		if e.Account == ledger.TradeAccount(f.trade.Id) {
+			escrow += e.Delta
+		}
+	}
+	if escrow != 30 {
+		t.Errorf("trade account = %d, want 30", escrow)
+	}
+
+	w := do(f.h, http.MethodPost, "/trades", "42", `{"userId":"42","toUserId":"43","offer":{"coin":71}}`)
+	if w.Code != http.StatusUnprocessableEntity || errorCode(t, w) != "insufficient_funds" {
+		t.Fatalf("offer beyond funds: status %d: %s", w.Code, w.Body)
+	}
+	if coin := f.coin(t, f.alice); coin != 70 {
+		t.Errorf("alice coin after failed offer = %d, want 70", coin)
+	}
+}
+
+func TestCloseTradeRefundsEscrow(t *testing.T) {
+	tests := []struct {
+		name  string
+		close func(t *testing.T, f *tradeFixture)
+		want  database.TradeStatus
+	}{
+		{"rejected", func(t *testing.T, f *tradeFixture) {
+			w := do(f.h, http.MethodPost, fmt.Sprintf("/trades/%d/reject", f.trade.Id), "43", "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("reject: status %d: %s", w.Code, w.Body)
+			}
+		}, database.TradeRejected},
+		{"cancelled", func(t *testing.T, f *tradeFixture) {
+			w := do(f.h, http.MethodDelete, fmt.Sprintf("/trades/%d", f.trade.Id), "42", "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("cancel: status %d: %s", w.Code, w.Body)
+			}
+		}, database.TradeCancelled},
+		{"expired", func(t *testing.T, f *tradeFixture) {
+			sweeper := trade.NewSweeper(f.store, time.Minute)
+			if n, err := sweeper.Sweep(time.Now()); err != nil || n != 0 {
+				t.Fatalf("sweep before expiry: expired %d, err %v", n, err)
+			}
+			if n, err := sweeper.Sweep(time.Now().Add(2 * time.Hour)); err != nil || n != 1 {
+				t.Fatalf("sweep after expiry: expired %d, err %v, want 1", n, err)
+			}
+		}, database.TradeExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTradeFixture(t, time.Hour)
+			tt.close(t, f)
+
+			if coin := f.coin(t, f.alice); coin != 100 {
+				t.Errorf("alice coin = %d, want the escrow back at 100", coin)
+			}
+			if coin, gems := f.coin(t, f.bob), f.gems(t, f.bob); coin != 50 || gems != 20 {
+				t.Errorf("bob has %d coin and %d gems, want 50 and 20", coin, gems)
+			}
+			if status := f.status(t); status != tt.want {
+				t.Errorf("trade status = %q, want %q", status, tt.want)
+			}
+			if a := f.card(t, f.a.Id); a.UserId != f.alice.Id {
+				t.Errorf("card a moved to user %d", a.UserId)
+			}
+			if held, err := f.store.Trades().IsCardInTrade(f.a.Id); err != nil || held {
+				t.Errorf("offered card held = %v, err %v, want released", held, err)
+			}
+
+			// A closed trade cannot be accepted.
+			w := do(f.h, http.MethodPost, fmt.Sprintf("/trades/%d/accept", f.trade.Id), "43", "")
+			if w.Code != http.StatusConflict || errorCode(t, w) != "trade_not_pending" {
+				t.Errorf("accept after close: status %d: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestAcceptTrade(t *testing.T) {
+	f := newTradeFixture(t, time.Hour)
+
+	w := do(f.h, http.MethodPost, fmt.Sprintf("/trades/%d/accept", f.trade.Id), "43", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("accept: status %d: %s", w.Code, w.Body)
+	}
+
+	// Each side gets what the other put in and the whole coins off the card
+	// it gave away.
+	if coin, gems := f.coin(t, f.alice), f.gems(t, f.alice); coin != 100-30+1 || gems != 20 {
+		t.Errorf("alice has %d coin and %d gems, want 71 and 20", coin, gems)
+	}
+	if coin, gems := f.coin(t, f.bob), f.gems(t, f.bob); coin != 50+30+2 || gems != 0 {
+		t.Errorf("bob has %d coin and %d gems, want 82 and 0", coin, gems)
+	}
+	if a := f.card(t, f.a.Id); a.UserId != f.bob.Id || a.Balance != 0.5 {
+		t.Errorf("card a = %+v, want bob's with 0.5", a)
+	}
+	if b := f.card(t, f.b.Id); b.UserId != f.alice.Id || b.Balance != 0.25 {
+		t.Errorf("card b = %+v, want alice's with 0.25", b)
+	}
+	if status := f.status(t); status != database.TradeAccepted {
+		t.Errorf("trade status = %q, want accepted", status)
+	}
+
+	var escrow int64
+	payouts := map[int]int64{}
+	for _, e := range f.store.LedgerEntries() {
+		if e.Account == ledger.TradeAccount(f.trade.Id) {
+			escrow += e.Delta
+		}
+		switch e.Reason {
+		case string(ledger.ReasonTradeCardPayout):
+			if e.UserId != nil {
+				payouts[*e.UserId] += e.Delta
+			}
+		case string(ledger.ReasonGpuWithdraw):
+			t.Errorf("settlement recorded as a withdrawal: %+v", e)
+		}
+	}
+	if escrow != 0 {
+		t.Errorf("trade account = %d after settling, want 0", escrow)
+	}
+	if payouts[f.alice.Id] != 1 || payouts[f.bob.Id] != 2 {
+		t.Errorf("card payouts = %v, want 1 to alice and 2 to bob", payouts)
+	}
+}
+
+func TestAcceptTradeFails(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		setup      func(f *tradeFixture)
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "offered card changed hands", ttl: time.Hour, setup: func(f *tradeFixture) {
+			carol := f.store.PutUser(database.User{ChatId: "44"})
+			f.a.UserId = carol.Id
+			f.store.PutCard(f.a)
+		}, wantStatus: http.StatusForbidden, wantCode: "not_owner"},
+		{name: "requested card changed hands", ttl: time.Hour, setup: func(f *tradeFixture) {
+			carol := f.store.PutUser(database.User{ChatId: "44"})
+			f.b.UserId = carol.Id
+			f.store.PutCard(f.b)
+		}, wantStatus: http.StatusForbidden, wantCode: "not_owner"},
+		{name: "requested card deleted", ttl: time.Hour, setup: func(f *tradeFixture) {
+			f.store.Cards().DeleteCard(f.b.Id)
+		}, wantStatus: http.StatusNotFound, wantCode: "card_not_found"},
+		{name: "requested card installed", ttl: time.Hour, setup: func(f *tradeFixture) {
+			f.store.PutStand(database.CardStand{UserId: f.bob.Id, CardId: &f.b.Id})
+		}, wantStatus: http.StatusConflict, wantCode: "card_installed"},
+		{name: "recipient short of the requested gems", ttl: time.Hour, setup: func(f *tradeFixture) {
+			f.bob.Gems = 19
+			f.store.PutUser(f.bob)
+		}, wantStatus: http.StatusUnprocessableEntity, wantCode: "insufficient_funds"},
+		{name: "expired", ttl: -time.Second, setup: func(f *tradeFixture) {},
+			wantStatus: http.StatusConflict, wantCode: "trade_expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTradeFixture(t, tt.ttl)
+			tt.setup(f)
+			before := f.store.LedgerEntries()
+
+			w := do(f.h, http.MethodPost, fmt.Sprintf("/trades/%d/accept", f.trade.Id), "43", "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if code := errorCode(t, w); code != tt.wantCode {
+				t.Errorf("code = %q, want %q", code, tt.wantCode)
+			}
+
+			if status := f.status(t); status != database.TradePending {
+				t.Errorf("trade status = %q, want pending", status)
+			}
+			if after := f.store.LedgerEntries(); len(after) != len(before) {
+				t.Errorf("failed accept wrote %d ledger entries", len(after)-len(before))
+			}
+			if coin := f.coin(t, f.alice); coin != 70 {
+				t.Errorf("alice coin = %d, want 70", coin)
+			}
+			if a, err := f.store.Cards().GetCardById(f.a.Id); err == nil && a.Balance != 1.5 {
+				t.Errorf("card a balance = %v, want 1.5", a.Balance)
+			}
+		})
+	}
+}
+
+func TestCreateTradeDuplicateCardIds(t *testing.T) {
+	tests := []struct {
+		name      string
+		offer     string
+		request   string
+		wantField string
+	}{
+		{"repeated offered card", "[1,1]", "[]", "offer.cardIds"},
+		{"repeated requested card", "[]", "[2,2]", "request.cardIds"},
+		{"card on both sides", "[1]", "[1]", "request.cardIds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newRouter(t, func(r chi.Router) {
+				r.Post("/trades", CreateTradeHandler(database.NewMemoryStore(), config.Game{TradeTTL: time.Hour}))
+			})
+			body := fmt.Sprintf(`{"userId":"42","toUserId":"43","offer":{"cardIds":%s},"request":{"cardIds":%s}}`, tt.offer, tt.request)
+			w := do(h, http.MethodPost, "/trades", "42", body)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", w.Code, w.Body)
+			}
+			var resp struct {
+				Details struct {
+					Fields []struct {
+						Field string `json:"field"`
+					} `json:"fields"`
+				} `json:"details"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Details.Fields) != 1 || resp.Details.Fields[0].Field != tt.wantField {
+				t.Errorf("fields = %+v, want %s", resp.Details.Fields, tt.wantField)
+			}
+		})
+	}
+}
@@ -3,20 +3,111 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 
 	"example.com/myapp/internal/database"
-	"github.com/go-chi/chi/v5"
-	"github.com/jmoiron/sqlx"
+	"example.com/myapp/internal/handlers/apierror"
+	"example.com/myapp/internal/logging"
 )
 
-func GetUserHandler(db *sqlx.DB) http.HandlerFunc {
+type LedgerEntryResponse struct {
+	Id           int64     `json:"id"`
+	Currency     string    `json:"currency"`
+	Delta        int64     `json:"delta"`
+	BalanceAfter int64     `json:"balance_after"`
+	Reason       string    `json:"reason"`
+	ReferenceId  string    `json:"reference_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type LedgerResponse struct {
+	Entries    []LedgerEntryResponse `json:"entries"`
+	NextCursor *string               `json:"next_cursor"`
+}
+
+func GetUserHandler(store database.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		chatId := chi.URLParam(r, "chatId")
-		user, err := database.GetUser(db, chatId)
+		chatId, ok := authorizedChatId(w, r, "chatId")
+		if !ok {
+			return
+		}
+
+		user, err := store.Users().GetUser(chatId)
 		if err != nil {
-			http.Error(w, "User not found", http.StatusNotFound)
+			writeError(w, r, err)
 			return
 		}
+		logging.AddAttrs(r.Context(), "user_id", user.Id)
 		json.NewEncoder(w).Encode(user)
 	}
 }
+
+func GetUserLedgerHandler(store database.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chatId, ok := authorizedChatId(w, r, "chatId")
+		if !ok {
+			return
+		}
+
+		var cursor int64
+		if s := r.URL.Query().Get("cursor"); s != "" {
+			c, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || c <= 0 {
+				writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid cursor"))
+				return
+			}
+			cursor = c
+		}
+
+		limit := 50
+		if s := r.URL.Query().Get("limit"); s != "" {
+			l, err := strconv.Atoi(s)
+			if err != nil || l <= 0 || l > 200 {
+				writeError(w, r, apierror.ErrBadRequest.WithMessage("Invalid limit"))
+				return
+			}
+			limit = l
+		}
+
+		user, err := store.Users().GetUser(chatId)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		logging.AddAttrs(r.Context(), "user_id", user.Id)
+
+		entries, err := store.Ledger().GetUserLedger(user.Id, cursor, limit+1)
+		if err != nil {
+			writeError(w, r, apierror.ErrInternal.WithMessage("Failed to get ledger").WithCause(err))
+			return
+		}
+
+		resp := LedgerResponse{Entries: make([]LedgerEntryResponse, 0, len(entries))}
+		if len(entries) > limit {
+			entries = entries[:limit]
+			next := strconv.FormatInt(entries[limit-1].Id, 10)
+			resp.NextCursor = &next
+		}
+
+		for _, e := range entries {
+			entry := LedgerEntryResponse{
+				Id:        e.Id,
+				Currency:  string(e.Currency),
+				Delta:     e.Delta,
+				Reason:    e.Reason,
+				CreatedAt: e.CreatedAt,
+			}
+			if e.BalanceAfter != nil {
+				entry.BalanceAfter = *e.BalanceAfter
+			}
+			if e.ReferenceId != nil {
+				entry.ReferenceId = *e.ReferenceId
+			}
+			resp.Entries = append(resp.Entries, entry)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
@@ -0,0 +1,168 @@
+package ledger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"sort"
+	"strconv"
+
+	"example.com/myapp/internal/database"
+)
+
+type Reason string
+
+const (
+	ReasonCaseOpen        Reason = "case_open"
+	ReasonSlotPurchase    Reason = "slot_purchase"
+	ReasonGpuWithdraw     Reason = "gpu_withdraw"
+	ReasonGpuInstall      Reason = "gpu_install"
+	ReasonGpuFreeze       Reason = "gpu_freeze"
+	ReasonGpuUpgrade      Reason = "gpu_upgrade"
+	ReasonGpuFuse         Reason = "gpu_fuse"
+	ReasonMarketPurchase  Reason = "market_purchase"
+	ReasonMarketSale      Reason = "market_sale"
+	ReasonTradeEscrow     Reason = "trade_escrow"
+	ReasonTradeRefund     Reason = "trade_refund"
+	ReasonTradeSettle     Reason = "trade_settle"
+	ReasonTradeCardPayout Reason = "trade_card_payout"
+)
+
+type Posting struct {
+	UserId       int
+	Currency     database.Currency
+	Delta        int64
+	Reason       Reason
+	ReferenceId  string
+	Counterparty string
+}
+
+func UserAccount(userId int) string {
+	return "user:" + strconv.Itoa(userId)
+}
+
+func CardAccount(cardId int) string {
+	return "card:" + strconv.Itoa(cardId)
+}
+
+// TradeAccount holds what a trade offer's sender has put in escrow and
+// passes on what the recipient pays when the trade settles.
+func TradeAccount(tradeId int64) string {
+	return "trade:" + strconv.FormatInt(tradeId, 10)
+}
+
+func SystemAccount(reason Reason) string {
+	return "system:" + string(reason)
+}
+
+// MarketAccount is the counterparty of both sides of a market sale. Buyers
+// pay in the full price and sellers are paid the price less the fee, so its
+// balance is the fees collected.
+const MarketAccount = "system:market"
+
+// Apply changes a user's currency and records the transfer as a pair of
+// entries: one on the user's account and the opposite one on the
+// counterparty (a card or a system account named after the reason).
+func Apply(tx database.Store, p Posting) error {
+	if p.Delta == 0 {
+		return nil
+	}
+
+	if err := tx.Users().AddUserCurrency(p.UserId, p.Currency, p.Delta); err != nil {
+		return err
+	}
+
+	balanceAfter, err := tx.Users().GetUserCurrency(p.UserId, p.Currency)
+	if err != nil {
+		return err
+	}
+
+	transferId, err := newTransferId()
+	if err != nil {
+		return err
+	}
+
+	counterparty := p.Counterparty
+	if counterparty == "" {
+		counterparty = SystemAccount(p.Reason)
+	}
+
+	var referenceId *string
+	if p.ReferenceId != "" {
+		referenceId = &p.ReferenceId
+	}
+
+	userId := p.UserId
+	err = tx.Ledger().InsertLedgerEntry(database.LedgerEntry{
+		TransferId:   transferId,
+		Account:      UserAccount(p.UserId),
+		UserId:       &userId,
+		Currency:     p.Currency,
+		Delta:        p.Delta,
+		BalanceAfter: &balanceAfter,
+		Reason:       string(p.Reason),
+		ReferenceId:  referenceId,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Ledger().InsertLedgerEntry(database.LedgerEntry{
+		TransferId:  transferId,
+		Account:     counterparty,
+		Currency:    p.Currency,
+		Delta:       -p.Delta,
+		Reason:      string(p.Reason),
+		ReferenceId: referenceId,
+	})
+}
+
+// ApplyAll applies postings in user id order. Every transaction that
+// touches several users goes through here, so two of them touching the same
+// users lock their rows in the same order and cannot deadlock. postings is
+// sorted in place.
+func ApplyAll(tx database.Store, postings []Posting) error {
+	sort.SliceStable(postings, func(i, j int) bool { return postings[i].UserId < postings[j].UserId })
+	for _, p := range postings {
+		if err := Apply(tx, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CardPayout takes the whole coins off card and returns the posting that
+// pays them to userId; the fraction of a coin stays on the card. Apply the
+// posting in the same transaction.
+func CardPayout(tx database.Store, card database.Card, userId int, reason Reason) (Posting, error) {
+	p := Posting{
+		UserId:       userId,
+		Currency:     database.CurrencyCoin,
+		Delta:        max(int64(math.Floor(float64(card.Balance))), 0),
+		Reason:       reason,
+		ReferenceId:  strconv.Itoa(card.Id),
+		Counterparty: CardAccount(card.Id),
+	}
+	if p.Delta == 0 {
+		return p, nil
+	}
+	return p, tx.Cards().SubtractCardBalance(card.Id, float32(p.Delta))
+}
+
+// PayOutCard pays the whole coins on card to userId and returns how many
+// it paid.
+func PayOutCard(tx database.Store, card database.Card, userId int, reason Reason) (int64, error) {
+	p, err := CardPayout(tx, card, userId, reason)
+	if err != nil {
+		return 0, err
+	}
+	return p.Delta, Apply(tx, p)
+}
+
+func newTransferId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
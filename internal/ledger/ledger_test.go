@@ -0,0 +1,73 @@
+package ledger
+
+import (
+	"testing"
+
+	"example.com/myapp/internal/database"
+)
+
+func TestApplyAllTouchesUsersInIdOrder(t *testing.T) {
+	store := database.NewMemoryStore()
+	for _, chatId := range []string{"1", "2", "3"} {
+		store.PutUser(database.User{ChatId: chatId, Coin: 10})
+	}
+
+	postings := []Posting{
+		{UserId: 3, Currency: database.CurrencyCoin, Delta: -1, Reason: ReasonTradeSettle},
+		{UserId: 1, Currency: database.CurrencyCoin, Delta: 1, Reason: ReasonTradeSettle},
+		{UserId: 2, Currency: database.CurrencyCoin, Delta: 2, Reason: ReasonTradeSettle},
+		{UserId: 1, Currency: database.CurrencyCoin, Delta: 3, Reason: ReasonTradeSettle},
+	}
+	if err := ApplyAll(store, postings); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int64
+	for _, userId := range []int{1, 2, 3} {
+		entries, err := store.Ledger().GetUserLedger(userId, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// GetUserLedger returns the newest entry first.
+		for i := len(entries) - 1; i >= 0; i-- {
+			got = append(got, entries[i].Id)
+		}
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Fatalf("entries were not written in user id order: %v", got)
+		}
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %d user entries, want 4", len(got))
+	}
+}
+
+func TestPayOutCardKeepsTheFraction(t *testing.T) {
+	store := database.NewMemoryStore()
+	user := store.PutUser(database.User{ChatId: "1"})
+	card := store.PutCard(database.Card{UserId: user.Id, Balance: 3.25})
+
+	paid, err := PayOutCard(store, card, user.Id, ReasonGpuWithdraw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paid != 3 {
+		t.Errorf("paid %d, want 3", paid)
+	}
+
+	card, err = store.Cards().GetCardById(card.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Balance != 0.25 {
+		t.Errorf("card balance = %v, want 0.25", card.Balance)
+	}
+	if coin, _ := store.Users().GetUserCurrency(user.Id, database.CurrencyCoin); coin != 3 {
+		t.Errorf("coin = %d, want 3", coin)
+	}
+
+	if paid, err := PayOutCard(store, card, user.Id, ReasonGpuWithdraw); err != nil || paid != 0 {
+		t.Errorf("paying out a fraction: paid %d, err %v", paid, err)
+	}
+}
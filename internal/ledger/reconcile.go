@@ -0,0 +1,60 @@
+package ledger
+
+import (
+	"database/sql"
+
+	"example.com/myapp/internal/database"
+)
+
+type Drift struct {
+	UserId   int
+	Currency database.Currency
+	Expected int64
+	Actual   int64
+}
+
+func (d Drift) Amount() int64 {
+	return d.Actual - d.Expected
+}
+
+type Report struct {
+	Checked             int
+	Drifts              []Drift
+	UnbalancedTransfers []string
+}
+
+// Reconcile recomputes every user balance tracked by the ledger, starting
+// from the balance implied by the user's first entry, and compares it with
+// the current value in the users table.
+func Reconcile(store database.Store) (Report, error) {
+	var report Report
+
+	totals, err := store.Ledger().GetLedgerTotals()
+	if err != nil {
+		return report, err
+	}
+
+	for _, t := range totals {
+		actual, err := store.Users().GetUserCurrency(t.UserId, t.Currency)
+		if err != nil && err != sql.ErrNoRows {
+			return report, err
+		}
+
+		report.Checked++
+		expected := t.Opening + t.Total
+		if actual != expected {
+			report.Drifts = append(report.Drifts, Drift{
+				UserId:   t.UserId,
+				Currency: t.Currency,
+				Expected: expected,
+				Actual:   actual,
+			})
+		}
+	}
+
+	report.UnbalancedTransfers, err = store.Ledger().GetUnbalancedTransfers()
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// New builds a logger writing to w. level is one of debug, info, warn or
+// error and format is text or json.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("logging: unknown level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q", format)
+	}
+}
+
+type scopeKey struct{}
+
+// scope holds the request logger and the attributes added while the request
+// passes through middleware and handlers.
+type scope struct {
+	mu     sync.Mutex
+	logger *slog.Logger
+	attrs  []any
+}
+
+// Middleware attaches a request-scoped logger to the context and writes one
+// access line per request once the handler has returned. It expects
+// middleware.RequestID to run before it and echoes the id back to the client.
+func Middleware(base *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			requestId := middleware.GetReqID(r.Context())
+			if requestId != "" {
+				w.Header().Set(middleware.RequestIDHeader, requestId)
+			}
+
+			s := &scope{logger: base.With(
+				"request_id", requestId,
+				"method", r.Method,
+				"path", r.URL.Path,
+			)}
+			ctx := context.WithValue(r.Context(), scopeKey{}, s)
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			if status >= 500 {
+				level = slog.LevelError
+			}
+			FromContext(ctx).Log(ctx, level, "request",
+				"status", status,
+				"bytes", ww.BytesWritten(),
+				"duration", time.Since(start),
+				"remote", r.RemoteAddr,
+			)
+		})
+	}
+}
+
+// AddAttrs adds key/value pairs to every later log line of the request.
+func AddAttrs(ctx context.Context, args ...any) {
+	s, ok := ctx.Value(scopeKey{}).(*scope)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, args...)
+	s.mu.Unlock()
+}
+
+// FromContext returns the request logger with the route pattern and any
+// attributes added so far, or the default logger outside a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	s, ok := ctx.Value(scopeKey{}).(*scope)
+	if !ok {
+		return slog.Default()
+	}
+
+	s.mu.Lock()
+	args := append([]any(nil), s.attrs...)
+	s.mu.Unlock()
+
+	if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+		args = append(args, "route", rctx.RoutePattern())
+	}
+	return s.logger.With(args...)
+}
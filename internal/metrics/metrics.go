@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method and route pattern.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	casesOpened = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_cases_opened_total",
+		Help: "Cases opened by reward type.",
+	}, []string{"reward_type"})
+
+	coinsWithdrawn = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "game_coins_withdrawn_total",
+		Help: "Coins withdrawn from GPU balances.",
+	})
+
+	slotsBought = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "game_slots_bought_total",
+		Help: "GPU slots bought.",
+	})
+
+	gpusInstalled = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "game_gpus_installed_total",
+		Help: "GPUs installed into a stand.",
+	})
+
+	gpusPulled = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "game_gpus_pulled_total",
+		Help: "GPUs removed from a stand.",
+	})
+
+	freezesUsed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "game_freezes_used_total",
+		Help: "Freezes spent on refuelling GPUs.",
+	})
+
+	gpusUpgraded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_gpus_upgraded_total",
+		Help: "GPU upgrades by the level reached.",
+	}, []string{"level"})
+
+	gpusFused = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_gpu_fusions_total",
+		Help: "GPU fusion attempts by outcome.",
+	}, []string{"outcome"})
+
+	marketSales = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_market_sales_total",
+		Help: "Market listings sold, by currency.",
+	}, []string{"currency"})
+
+	marketFees = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_market_fees_total",
+		Help: "Fees taken from market sales, by currency.",
+	}, []string{"currency"})
+
+	tradeEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "game_trade_events_total",
+		Help: "Trade offers created and closed, by event.",
+	}, []string{"event"})
+
+	miningTicks = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "game_mining_ticks_total",
+		Help: "Mining ticks applied to installed GPUs.",
+	})
+
+	balanceMined = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "game_balance_mined_total",
+		Help: "Balance accrued on GPUs by mining.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		casesOpened,
+		coinsWithdrawn,
+		slotsBought,
+		gpusInstalled,
+		gpusPulled,
+		freezesUsed,
+		gpusUpgraded,
+		gpusFused,
+		marketSales,
+		marketFees,
+		tradeEvents,
+		miningTicks,
+		balanceMined,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RegisterDB exports the pool statistics of db. Registering the same pool
+// twice is a no-op.
+func RegisterDB(db *sql.DB, name string) error {
+	err := registry.Register(collectors.NewDBStatsCollector(db, name))
+	if _, ok := err.(prometheus.AlreadyRegisteredError); ok {
+		return nil
+	}
+	return err
+}
+
+// Middleware records request counts and latency labelled with the chi route
+// pattern, so paths containing chat or card ids share one series.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+func CaseOpened(rewardType string) {
+	casesOpened.WithLabelValues(rewardType).Inc()
+}
+
+func CoinsWithdrawn(amount int64) {
+	coinsWithdrawn.Add(float64(amount))
+}
+
+func SlotBought() {
+	slotsBought.Inc()
+}
+
+func GpuInstalled() {
+	gpusInstalled.Inc()
+}
+
+func GpuPulled() {
+	gpusPulled.Inc()
+}
+
+func FreezeUsed() {
+	freezesUsed.Inc()
+}
+
+func GpuUpgraded(level int) {
+	gpusUpgraded.WithLabelValues(strconv.Itoa(level)).Inc()
+}
+
+func GpuFused(success bool) {
+	outcome := "failed"
+	if success {
+		outcome = "success"
+	}
+	gpusFused.WithLabelValues(outcome).Inc()
+}
+
+func MarketSale(currency string, fee int64) {
+	marketSales.WithLabelValues(currency).Inc()
+	marketFees.WithLabelValues(currency).Add(float64(fee))
+}
+
+// Trade counts a trade event: "created" or the status it closed with.
+func Trade(event string) {
+	tradeEvents.WithLabelValues(event).Inc()
+}
+
+func Mined(ticks int, amount float64) {
+	miningTicks.Add(float64(ticks))
+	balanceMined.Add(amount)
+}
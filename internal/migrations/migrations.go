@@ -0,0 +1,265 @@
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+const trackingSchema = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		appliedAt DATETIME NOT NULL,
+		PRIMARY KEY (version)
+	)`
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type applied struct {
+	Version   int       `db:"version"`
+	AppliedAt time.Time `db:"appliedAt"`
+}
+
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migrations: unexpected file %s", name)
+		}
+
+		prefix, title, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migrations: bad version in %s", name)
+		}
+
+		body, err := files.ReadFile("sql/" + name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migrations: %04d_%s has no up file", m.Version, m.Name)
+		}
+		// Up undoes a half-applied migration with the matching down
+		// statements, so the down file reverts the up file statement by
+		// statement, in reverse order.
+		if m.Down != "" && len(statements(m.Down)) != len(statements(m.Up)) {
+			return nil, fmt.Errorf("migrations: %04d_%s has %d up statements but %d down statements",
+				m.Version, m.Name, len(statements(m.Up)), len(statements(m.Down)))
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func Statuses(db *sqlx.DB) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(trackingSchema); err != nil {
+		return nil, err
+	}
+
+	var rows []applied
+	if err := db.Select(&rows, "SELECT version, appliedAt FROM schema_migrations"); err != nil {
+		return nil, err
+	}
+	appliedAt := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		appliedAt[row.Version] = row.AppliedAt
+	}
+
+	statuses := make([]Status, 0, len(migrations))
+	for _, m := range migrations {
+		s := Status{Migration: m}
+		if t, ok := appliedAt[m.Version]; ok {
+			s.AppliedAt = &t
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+func Version(db *sqlx.DB) (int, error) {
+	var version int
+	err := db.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations")
+	return version, err
+}
+
+func Pending(db *sqlx.DB) (int, error) {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func Up(db *sqlx.DB, out io.Writer) error {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			continue
+		}
+		if err := apply(db, s.Migration); err != nil {
+			return fmt.Errorf("migrations: %04d_%s: %w", s.Version, s.Name, err)
+		}
+		_, err := db.Exec("INSERT INTO schema_migrations (version, name, appliedAt) VALUES (?, ?, NOW())", s.Version, s.Name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "applied %04d_%s\n", s.Version, s.Name)
+	}
+	return nil
+}
+
+// Down reverts the most recently applied migration.
+func Down(db *sqlx.DB, out io.Writer) error {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return err
+	}
+
+	for i := len(statuses) - 1; i >= 0; i-- {
+		s := statuses[i]
+		if s.AppliedAt == nil {
+			continue
+		}
+		if s.Down == "" {
+			return fmt.Errorf("migrations: %04d_%s cannot be reverted", s.Version, s.Name)
+		}
+		if _, err := execStatements(db, statements(s.Down)); err != nil {
+			return fmt.Errorf("migrations: %04d_%s: %w", s.Version, s.Name, err)
+		}
+		if _, err := db.Exec("DELETE FROM schema_migrations WHERE version = ?", s.Version); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "reverted %04d_%s\n", s.Version, s.Name)
+		return nil
+	}
+
+	fmt.Fprintln(out, "nothing to revert")
+	return nil
+}
+
+func PrintStatus(db *sqlx.DB, out io.Writer) error {
+	statuses, err := Statuses(db)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		state := "pending"
+		if s.AppliedAt != nil {
+			state = "applied " + s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(out, "%04d_%s\t%s\n", s.Version, s.Name, state)
+	}
+	return nil
+}
+
+func Run(db *sqlx.DB, command string, out io.Writer) error {
+	switch command {
+	case "up":
+		return Up(db, out)
+	case "down":
+		return Down(db, out)
+	case "status":
+		return PrintStatus(db, out)
+	}
+	return fmt.Errorf("unknown command %q, expected up, down or status", command)
+}
+
+// apply runs the up statements of m. MySQL commits every schema change on its
+// own, so if a statement fails the ones before it are reverted with their down
+// statements instead of a transaction rollback.
+func apply(db *sqlx.DB, m Migration) error {
+	done, err := execStatements(db, statements(m.Up))
+	if err == nil || done == 0 {
+		return err
+	}
+
+	down := statements(m.Down)
+	if len(down) == 0 {
+		return fmt.Errorf("%w (%d statements were applied and cannot be reverted)", err, done)
+	}
+	if _, undoErr := execStatements(db, down[len(down)-done:]); undoErr != nil {
+		return fmt.Errorf("%w (reverting the %d applied statements failed: %v)", err, done, undoErr)
+	}
+	return err
+}
+
+// execStatements runs stmts in order and returns how many succeeded.
+func execStatements(db *sqlx.DB, stmts []string) (int, error) {
+	for i, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return i, err
+		}
+	}
+	return len(stmts), nil
+}
+
+func statements(script string) []string {
+	var stmts []string
+	for _, stmt := range strings.Split(script, ";") {
+		if strings.TrimSpace(stmt) != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
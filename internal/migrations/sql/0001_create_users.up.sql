@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS users (
+	id INT NOT NULL AUTO_INCREMENT,
+	chatId VARCHAR(255) NOT NULL,
+	username VARCHAR(255) NOT NULL DEFAULT '',
+	firstname VARCHAR(255) NOT NULL DEFAULT '',
+	captureCounter INT NOT NULL DEFAULT 0,
+	balance BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	meflvl INT NOT NULL DEFAULT 0,
+	timelvl INT NOT NULL DEFAULT 0,
+	farmtime INT NOT NULL DEFAULT 0,
+	createdAt DATETIME NOT NULL,
+	updatedAt DATETIME NOT NULL,
+	slots INT NOT NULL DEFAULT 0,
+	fullSlots INT NOT NULL DEFAULT 0,
+	gems INT NOT NULL DEFAULT 0,
+	takeBonus INT NOT NULL DEFAULT 0,
+	chests INT NOT NULL DEFAULT 0,
+	famMoney INT NOT NULL DEFAULT 0,
+	stones INT NOT NULL DEFAULT 0,
+	snows INT NOT NULL DEFAULT 0,
+	freeze INT NOT NULL DEFAULT 0,
+	oil INT NOT NULL DEFAULT 0,
+	donate INT NOT NULL DEFAULT 0,
+	coin INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (id)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS cards (
+	id INT NOT NULL AUTO_INCREMENT,
+	userId INT NOT NULL,
+	lvl INT NOT NULL DEFAULT 0,
+	fuel INT NOT NULL DEFAULT 100,
+	balance FLOAT NOT NULL DEFAULT 0,
+	createdAt DATETIME NOT NULL,
+	updatedAt DATETIME NOT NULL,
+	PRIMARY KEY (id)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS cardStands (
+	id INT NOT NULL AUTO_INCREMENT,
+	userId INT NOT NULL,
+	cardId INT NULL,
+	createdAt DATETIME NOT NULL,
+	updatedAt DATETIME NOT NULL,
+	PRIMARY KEY (id)
+);
@@ -0,0 +1,4 @@
+ALTER TABLE cardStands DROP INDEX card_stands_card_id;
+ALTER TABLE cardStands DROP INDEX card_stands_user_id;
+ALTER TABLE cards DROP INDEX cards_user_id;
+ALTER TABLE users DROP INDEX users_chat_id;
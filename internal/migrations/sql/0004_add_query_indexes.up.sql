@@ -0,0 +1,4 @@
+ALTER TABLE users ADD INDEX users_chat_id (chatId);
+ALTER TABLE cards ADD INDEX cards_user_id (userId);
+ALTER TABLE cardStands ADD INDEX card_stands_user_id (userId);
+ALTER TABLE cardStands ADD UNIQUE INDEX card_stands_card_id (cardId);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS ledger_entries (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	transferId CHAR(32) NOT NULL,
+	account VARCHAR(64) NOT NULL,
+	userId INT NULL,
+	currency VARCHAR(16) NOT NULL,
+	delta BIGINT NOT NULL,
+	balanceAfter BIGINT NULL,
+	reason VARCHAR(32) NOT NULL,
+	referenceId VARCHAR(64) NULL,
+	createdAt DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	KEY ledger_entries_user (userId, id),
+	KEY ledger_entries_transfer (transferId)
+);
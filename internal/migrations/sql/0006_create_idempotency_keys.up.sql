@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	chatId VARCHAR(64) NOT NULL,
+	idemKey VARCHAR(255) NOT NULL,
+	requestHash CHAR(64) NOT NULL,
+	statusCode INT NULL,
+	headers TEXT NULL,
+	body MEDIUMBLOB NULL,
+	createdAt DATETIME NOT NULL,
+	expiresAt DATETIME NOT NULL,
+	PRIMARY KEY (chatId, idemKey),
+	KEY idempotency_keys_expires (expiresAt)
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS job_runs (
+	name VARCHAR(64) NOT NULL,
+	lastRunAt DATETIME(6) NOT NULL,
+	PRIMARY KEY (name)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS card_fusions (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	userId INT NOT NULL,
+	cardId INT NOT NULL,
+	lvl INT NOT NULL,
+	success BOOLEAN NOT NULL,
+	consumedCardIds VARCHAR(255) NOT NULL,
+	createdAt DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	KEY card_fusions_user (userId, id)
+);
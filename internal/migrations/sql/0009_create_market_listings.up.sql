@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS market_listings (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	sellerId INT NOT NULL,
+	buyerId INT NULL,
+	cardId INT NOT NULL,
+	currency VARCHAR(16) NOT NULL,
+	price BIGINT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	createdAt DATETIME NOT NULL,
+	updatedAt DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	KEY market_listings_card (cardId, status),
+	KEY market_listings_price (status, price, id),
+	KEY market_listings_seller (sellerId, id)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS trade_offer_items;
+DROP TABLE IF EXISTS trade_offers;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS trade_offers (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	fromUserId INT NOT NULL,
+	toUserId INT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	expiresAt DATETIME NOT NULL,
+	createdAt DATETIME NOT NULL,
+	updatedAt DATETIME NOT NULL,
+	PRIMARY KEY (id),
+	KEY trade_offers_from (fromUserId, status),
+	KEY trade_offers_to (toUserId, status),
+	KEY trade_offers_expiry (status, expiresAt)
+);
+
+CREATE TABLE IF NOT EXISTS trade_offer_items (
+	id BIGINT NOT NULL AUTO_INCREMENT,
+	tradeId BIGINT NOT NULL,
+	side VARCHAR(16) NOT NULL,
+	cardId INT NULL,
+	currency VARCHAR(16) NULL,
+	amount BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (id),
+	KEY trade_offer_items_trade (tradeId),
+	KEY trade_offer_items_card (cardId)
+);
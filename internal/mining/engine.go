@@ -0,0 +1,122 @@
+package mining
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/metrics"
+)
+
+const jobName = "mining_accrual"
+
+// Lock elects the replica that runs the engine. Acquire reports whether this
+// process holds the lock and must not block while another replica does.
+type Lock interface {
+	Acquire(ctx context.Context) (bool, error)
+	Release(ctx context.Context) error
+}
+
+// LocalLock is always held. It is for a single process, e.g. with the memory
+// store.
+type LocalLock struct{}
+
+func (LocalLock) Acquire(ctx context.Context) (bool, error) { return true, nil }
+func (LocalLock) Release(ctx context.Context) error         { return nil }
+
+// Engine accrues balance on installed cards once per game.MiningTick.
+type Engine struct {
+	store database.Store
+	lock  Lock
+	game  config.Game
+	poll  time.Duration
+}
+
+func NewEngine(store database.Store, lock Lock, game config.Game, poll time.Duration) *Engine {
+	return &Engine{store: store, lock: lock, game: game, poll: poll}
+}
+
+// Run checks every poll interval whether ticks are due and applies them
+// while this replica holds the lock. It returns once ctx is cancelled.
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.poll)
+	defer ticker.Stop()
+	defer e.lock.Release(context.Background())
+
+	for {
+		e.runOnce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Engine) runOnce(ctx context.Context) {
+	leader, err := e.lock.Acquire(ctx)
+	if err != nil {
+		slog.Error("mining: acquiring leader lock failed", "err", err)
+		return
+	}
+	if !leader {
+		return
+	}
+
+	ticks, cards, err := e.Tick(time.Now())
+	if err != nil {
+		slog.Error("mining: tick failed", "err", err)
+		return
+	}
+	if ticks > 0 {
+		slog.Info("mining: accrued", "ticks", ticks, "cards", cards)
+	}
+}
+
+// Tick applies every mining tick that has elapsed since the last run as of
+// now, in one transaction. The first run only records the starting point.
+// It returns the number of ticks applied and the cards that mined.
+func (e *Engine) Tick(now time.Time) (int, int, error) {
+	var ticks, cards int
+	var mined float64
+	err := e.store.WithTx(func(tx database.Store) error {
+		lastRun, err := tx.Jobs().GetJobLastRunForUpdate(jobName)
+		if errors.Is(err, sql.ErrNoRows) {
+			return tx.Jobs().SetJobLastRun(jobName, now)
+		}
+		if err != nil {
+			return err
+		}
+
+		ticks = int(now.Sub(lastRun) / e.game.MiningTick)
+		if ticks <= 0 {
+			return nil
+		}
+
+		installed, err := tx.Cards().GetInstalledCardsForUpdate()
+		if err != nil {
+			return err
+		}
+		for _, card := range installed {
+			balance, fuel := Accrue(card, ticks, e.game)
+			if err := tx.Cards().UpdateCardMining(card.Id, balance, fuel); err != nil {
+				return err
+			}
+			mined += float64(balance - card.Balance)
+		}
+		cards = len(installed)
+
+		return tx.Jobs().SetJobLastRun(jobName, lastRun.Add(time.Duration(ticks)*e.game.MiningTick))
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	metrics.Mined(ticks, mined)
+	return ticks, cards, nil
+}
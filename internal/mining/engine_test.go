@@ -0,0 +1,152 @@
+package mining
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
+)
+
+func newMiningStore(fuel int, installed bool) (*database.MemoryStore, database.Card) {
+	store := database.NewMemoryStore()
+	user := store.PutUser(database.User{ChatId: "42"})
+	card := store.PutCard(database.Card{UserId: user.Id, Lvl: 1, Fuel: fuel})
+	if installed {
+		store.PutStand(database.CardStand{UserId: user.Id, CardId: &card.Id})
+	}
+	return store, card
+}
+
+func TestEngineTick(t *testing.T) {
+	game := testGame
+	game.MiningTick = time.Minute
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		fuel        int
+		uninstalled bool
+		elapsed     time.Duration
+		wantTicks   int
+		wantBalance float32
+		wantFuel    int
+	}{
+		{name: "less than a tick", fuel: 100, elapsed: 59 * time.Second,
+			wantTicks: 0, wantBalance: 0, wantFuel: 100},
+		{name: "one tick", fuel: 100, elapsed: time.Minute,
+			wantTicks: 1, wantBalance: 0.5, wantFuel: 98},
+		{name: "missed ticks catch up", fuel: 100, elapsed: 5*time.Minute + 30*time.Second,
+			wantTicks: 5, wantBalance: 2.5, wantFuel: 90},
+		{name: "fuel runs out partway", fuel: 5, elapsed: 5 * time.Minute,
+			wantTicks: 5, wantBalance: 1.5, wantFuel: 0},
+		{name: "uninstalled card does not mine", fuel: 100, uninstalled: true, elapsed: 5 * time.Minute,
+			wantTicks: 5, wantBalance: 0, wantFuel: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, card := newMiningStore(tt.fuel, !tt.uninstalled)
+			e := NewEngine(store, LocalLock{}, game, time.Second)
+
+			if ticks, _, err := e.Tick(start); err != nil || ticks != 0 {
+				t.Fatalf("first run: %d ticks, err %v, want 0", ticks, err)
+			}
+			ticks, _, err := e.Tick(start.Add(tt.elapsed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ticks != tt.wantTicks {
+				t.Errorf("ticks = %d, want %d", ticks, tt.wantTicks)
+			}
+
+			got, err := store.Cards().GetCardById(card.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Balance != tt.wantBalance || got.Fuel != tt.wantFuel {
+				t.Errorf("got balance %v and fuel %d, want %v and %d", got.Balance, got.Fuel, tt.wantBalance, tt.wantFuel)
+			}
+
+			// Only whole ticks are consumed; the rest carries over.
+			lastRun, err := store.Jobs().GetJobLastRunForUpdate(jobName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := start.Add(time.Duration(tt.wantTicks) * game.MiningTick); !lastRun.Equal(want) {
+				t.Errorf("last run = %v, want %v", lastRun, want)
+			}
+		})
+	}
+}
+
+type fakeLock struct {
+	held bool
+	err  error
+}
+
+func (l fakeLock) Acquire(ctx context.Context) (bool, error) { return l.held, l.err }
+func (l fakeLock) Release(ctx context.Context) error         { return nil }
+
+func TestEngineRunsOnlyAsLeader(t *testing.T) {
+	game := testGame
+	game.MiningTick = time.Minute
+
+	tests := []struct {
+		name      string
+		lock      fakeLock
+		wantMined bool
+	}{
+		{name: "leader", lock: fakeLock{held: true}, wantMined: true},
+		{name: "lock held elsewhere", lock: fakeLock{held: false}},
+		{name: "lock error", lock: fakeLock{err: errors.New("connection refused")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, card := newMiningStore(100, true)
+			lastRun := time.Now().Add(-5 * time.Minute)
+			if err := store.Jobs().SetJobLastRun(jobName, lastRun); err != nil {
+				t.Fatal(err)
+			}
+
+			NewEngine(store, tt.lock, game, time.Second).runOnce(context.Background())
+
+			got, err := store.Cards().GetCardById(card.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mined := got.Balance > 0; mined != tt.wantMined {
+				t.Errorf("mined = %v (balance %v), want %v", mined, got.Balance, tt.wantMined)
+			}
+			after, err := store.Jobs().GetJobLastRunForUpdate(jobName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if moved := !after.Equal(lastRun); moved != tt.wantMined {
+				t.Errorf("last run moved = %v, want %v", moved, tt.wantMined)
+			}
+		})
+	}
+}
+
+func TestEngineFirstRunOnlyRecordsStart(t *testing.T) {
+	store, card := newMiningStore(100, true)
+	e := NewEngine(store, LocalLock{}, config.Game{MiningTick: time.Minute, IncomePerLevel: 1}, time.Second)
+
+	if _, err := store.Jobs().GetJobLastRunForUpdate(jobName); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("job already recorded: %v", err)
+	}
+	now := time.Now()
+	if ticks, cards, err := e.Tick(now); err != nil || ticks != 0 || cards != 0 {
+		t.Fatalf("got %d ticks, %d cards, err %v", ticks, cards, err)
+	}
+	got, err := store.Cards().GetCardById(card.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Balance != 0 {
+		t.Errorf("balance = %v, want 0", got.Balance)
+	}
+}
@@ -0,0 +1,80 @@
+package mining
+
+import (
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
+)
+
+// Income is what a card of level lvl earns per mining tick. The accrual
+// engine and the income shown to players both use it, so the two cannot
+// disagree.
+func Income(lvl int, game config.Game) float32 {
+	return float32(max(lvl, 1)) * game.IncomePerLevel
+}
+
+// FuelBurn is the fuel a card of level lvl uses per mining tick: the base
+// rate plus game.FuelPerLevel for every level above the first.
+func FuelBurn(lvl int, game config.Game) int {
+	return game.FuelPerTick + (max(lvl, 1)-1)*game.FuelPerLevel
+}
+
+// TicksUntilEmpty is how many more ticks card can mine on its fuel. A last
+// partial tank still counts as a tick. It is -1 if the card burns no fuel.
+func TicksUntilEmpty(card database.Card, game config.Game) int {
+	if card.Fuel <= 0 {
+		return 0
+	}
+	burn := FuelBurn(card.Lvl, game)
+	if burn <= 0 {
+		return -1
+	}
+	return (card.Fuel + burn - 1) / burn
+}
+
+// Accrue advances card by up to ticks mining ticks and returns its new
+// balance and fuel. A card earns nothing once its fuel is gone.
+func Accrue(card database.Card, ticks int, game config.Game) (float32, int) {
+	if card.Fuel <= 0 || ticks <= 0 {
+		return card.Balance, max(card.Fuel, 0)
+	}
+
+	mined := ticks
+	if left := TicksUntilEmpty(card, game); left >= 0 {
+		mined = min(ticks, left)
+	}
+
+	balance := card.Balance + Income(card.Lvl, game)*float32(mined)
+	fuel := max(card.Fuel-mined*FuelBurn(card.Lvl, game), 0)
+	return balance, fuel
+}
+
+// Status describes how a card is mining. Stalled cards are out of fuel and
+// earn nothing until refuelled. The projections assume the card stays
+// installed and are null for cards that burn no fuel.
+type Status struct {
+	Installed         bool     `json:"installed"`
+	Stalled           bool     `json:"stalled"`
+	IncomePerTick     float32  `json:"income_per_tick"`
+	FuelPerTick       int      `json:"fuel_per_tick"`
+	TickSeconds       int64    `json:"tick_seconds"`
+	SecondsUntilEmpty *int64   `json:"seconds_until_empty"`
+	ProjectedEarnings *float32 `json:"projected_earnings"`
+}
+
+func CardStatus(card database.Card, installed bool, game config.Game) Status {
+	status := Status{
+		Installed:     installed,
+		Stalled:       card.Fuel <= 0,
+		IncomePerTick: Income(card.Lvl, game),
+		FuelPerTick:   FuelBurn(card.Lvl, game),
+		TickSeconds:   int64(game.MiningTick.Seconds()),
+	}
+
+	if ticks := TicksUntilEmpty(card, game); ticks >= 0 {
+		seconds := int64(ticks) * status.TickSeconds
+		earnings := status.IncomePerTick * float32(ticks)
+		status.SecondsUntilEmpty = &seconds
+		status.ProjectedEarnings = &earnings
+	}
+	return status
+}
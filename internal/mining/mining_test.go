@@ -0,0 +1,143 @@
+package mining
+
+import (
+	"testing"
+	"time"
+
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
+)
+
+var testGame = config.Game{IncomePerLevel: 0.5, FuelPerTick: 2, FuelPerLevel: 1}
+
+func TestIncomeAndFuelBurn(t *testing.T) {
+	tests := []struct {
+		lvl        int
+		wantIncome float32
+		wantBurn   int
+	}{
+		{lvl: 0, wantIncome: 0.5, wantBurn: 2},
+		{lvl: 1, wantIncome: 0.5, wantBurn: 2},
+		{lvl: 2, wantIncome: 1, wantBurn: 3},
+		{lvl: 5, wantIncome: 2.5, wantBurn: 6},
+	}
+	for _, tt := range tests {
+		if got := Income(tt.lvl, testGame); got != tt.wantIncome {
+			t.Errorf("Income(%d) = %v, want %v", tt.lvl, got, tt.wantIncome)
+		}
+		if got := FuelBurn(tt.lvl, testGame); got != tt.wantBurn {
+			t.Errorf("FuelBurn(%d) = %d, want %d", tt.lvl, got, tt.wantBurn)
+		}
+	}
+}
+
+func TestAccrue(t *testing.T) {
+	noBurn := testGame
+	noBurn.FuelPerTick, noBurn.FuelPerLevel = 0, 0
+
+	tests := []struct {
+		name        string
+		card        database.Card
+		ticks       int
+		game        config.Game
+		wantBalance float32
+		wantFuel    int
+	}{
+		{name: "one tick", card: database.Card{Lvl: 1, Fuel: 100, Balance: 1}, ticks: 1, game: testGame,
+			wantBalance: 1.5, wantFuel: 98},
+		{name: "missed ticks catch up", card: database.Card{Lvl: 2, Fuel: 100}, ticks: 4, game: testGame,
+			wantBalance: 4, wantFuel: 88},
+		{name: "fuel runs out partway", card: database.Card{Lvl: 3, Fuel: 10}, ticks: 5, game: testGame,
+			wantBalance: 4.5, wantFuel: 0},
+		{name: "last partial tank mines a full tick", card: database.Card{Lvl: 1, Fuel: 1}, ticks: 3, game: testGame,
+			wantBalance: 0.5, wantFuel: 0},
+		{name: "no fuel", card: database.Card{Lvl: 1, Fuel: 0, Balance: 2}, ticks: 3, game: testGame,
+			wantBalance: 2, wantFuel: 0},
+		{name: "negative fuel", card: database.Card{Lvl: 1, Fuel: -5}, ticks: 3, game: testGame,
+			wantBalance: 0, wantFuel: 0},
+		{name: "no ticks", card: database.Card{Lvl: 1, Fuel: 100, Balance: 2}, ticks: 0, game: testGame,
+			wantBalance: 2, wantFuel: 100},
+		{name: "level 0 mines as level 1", card: database.Card{Lvl: 0, Fuel: 100}, ticks: 2, game: testGame,
+			wantBalance: 1, wantFuel: 96},
+		{name: "no fuel burn", card: database.Card{Lvl: 1, Fuel: 1}, ticks: 10, game: noBurn,
+			wantBalance: 5, wantFuel: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			balance, fuel := Accrue(tt.card, tt.ticks, tt.game)
+			if balance != tt.wantBalance || fuel != tt.wantFuel {
+				t.Errorf("got balance %v and fuel %d, want %v and %d", balance, fuel, tt.wantBalance, tt.wantFuel)
+			}
+		})
+	}
+}
+
+func TestCardStatus(t *testing.T) {
+	game := testGame
+	game.MiningTick = time.Hour
+	noBurn := game
+	noBurn.FuelPerTick, noBurn.FuelPerLevel = 0, 0
+
+	tests := []struct {
+		name      string
+		card      database.Card
+		game      config.Game
+		wantTicks int
+		want      Status
+	}{
+		{name: "full tank", card: database.Card{Lvl: 1, Fuel: 100}, game: game, wantTicks: 50,
+			want: Status{IncomePerTick: 0.5, FuelPerTick: 2, SecondsUntilEmpty: ptr[int64](50 * 3600), ProjectedEarnings: ptr[float32](25)}},
+		{name: "partial tank counts as a tick", card: database.Card{Lvl: 2, Fuel: 7}, game: game, wantTicks: 3,
+			want: Status{IncomePerTick: 1, FuelPerTick: 3, SecondsUntilEmpty: ptr[int64](3 * 3600), ProjectedEarnings: ptr[float32](3)}},
+		{name: "less than one tick of fuel", card: database.Card{Lvl: 3, Fuel: 1}, game: game, wantTicks: 1,
+			want: Status{IncomePerTick: 1.5, FuelPerTick: 4, SecondsUntilEmpty: ptr[int64](3600), ProjectedEarnings: ptr[float32](1.5)}},
+		{name: "no fuel", card: database.Card{Lvl: 1, Fuel: 0}, game: game, wantTicks: 0,
+			want: Status{Stalled: true, IncomePerTick: 0.5, FuelPerTick: 2, SecondsUntilEmpty: ptr[int64](0), ProjectedEarnings: ptr[float32](0)}},
+		{name: "negative fuel", card: database.Card{Lvl: 1, Fuel: -3}, game: game, wantTicks: 0,
+			want: Status{Stalled: true, IncomePerTick: 0.5, FuelPerTick: 2, SecondsUntilEmpty: ptr[int64](0), ProjectedEarnings: ptr[float32](0)}},
+		{name: "level 0 counts as level 1", card: database.Card{Lvl: 0, Fuel: 10}, game: game, wantTicks: 5,
+			want: Status{IncomePerTick: 0.5, FuelPerTick: 2, SecondsUntilEmpty: ptr[int64](5 * 3600), ProjectedEarnings: ptr[float32](2.5)}},
+		{name: "no fuel burn", card: database.Card{Lvl: 1, Fuel: 10}, game: noBurn, wantTicks: -1,
+			want: Status{IncomePerTick: 0.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TicksUntilEmpty(tt.card, tt.game); got != tt.wantTicks {
+				t.Errorf("TicksUntilEmpty = %d, want %d", got, tt.wantTicks)
+			}
+
+			got := CardStatus(tt.card, true, tt.game)
+			want := tt.want
+			want.Installed = true
+			want.TickSeconds = 3600
+			if got.Installed != want.Installed || got.Stalled != want.Stalled ||
+				got.IncomePerTick != want.IncomePerTick || got.FuelPerTick != want.FuelPerTick ||
+				got.TickSeconds != want.TickSeconds {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+			if !equalPtr(got.SecondsUntilEmpty, want.SecondsUntilEmpty) {
+				t.Errorf("seconds until empty = %v, want %v", deref(got.SecondsUntilEmpty), deref(want.SecondsUntilEmpty))
+			}
+			if !equalPtr(got.ProjectedEarnings, want.ProjectedEarnings) {
+				t.Errorf("projected earnings = %v, want %v", deref(got.ProjectedEarnings), deref(want.ProjectedEarnings))
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// deref formats p for a test failure: its value, or nil.
+func deref[T any](p *T) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
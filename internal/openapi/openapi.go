@@ -0,0 +1,273 @@
+package openapi
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Operation describes one route. Request and Response are zero values of
+// the body types, e.g. InstallGpuRequest{}, and are turned into schemas by
+// reflection.
+type Operation struct {
+	Id          string
+	Summary     string
+	Tag         string
+	Query       []Param
+	Request     any
+	Response    any
+	ContentType string
+	Public      bool
+	Idempotent  bool
+	Deprecated  bool
+}
+
+type Param struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Security   []map[string][]string           `json:"security"`
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components components                      `json:"components"`
+}
+
+type components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type operation struct {
+	OperationId string                 `json:"operationId"`
+	Summary     string                 `json:"summary,omitempty"`
+	Tags        []string               `json:"tags,omitempty"`
+	Deprecated  bool                   `json:"deprecated,omitempty"`
+	Parameters  []parameter            `json:"parameters,omitempty"`
+	RequestBody *body                  `json:"requestBody,omitempty"`
+	Responses   map[string]body        `json:"responses"`
+	Security    *[]map[string][]string `json:"security,omitempty"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+type body struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// errorBody mirrors the envelope written by apierror.
+type errorBody struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+var pathParam = regexp.MustCompile(`\{([^}:]+)(?::[^}]*)?\}`)
+
+// Build describes every route registered on router. Operations are looked
+// up by "METHOD /pattern" with prefix stripped, so one entry covers both a
+// versioned route and its unversioned alias. Routes without an operation
+// make Build fail, which keeps the document in step with the router.
+func Build(info Info, router chi.Routes, prefix string, ops map[string]Operation) (*Document, error) {
+	s := &schemas{components: map[string]*Schema{}}
+	doc := &Document{
+		OpenAPI:  "3.0.3",
+		Info:     info,
+		Security: []map[string][]string{{"tma": {}}},
+		Paths:    map[string]map[string]operation{},
+	}
+
+	type route struct{ method, pattern string }
+	var routes []route
+	err := chi.Walk(router, func(method, pattern string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routes = append(routes, route{method, pattern})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	versioned := map[string]bool{}
+	for _, rt := range routes {
+		if p, ok := strings.CutPrefix(rt.pattern, prefix); ok {
+			versioned[rt.method+" "+p] = true
+		}
+	}
+
+	var missing []string
+	for _, rt := range routes {
+		unprefixed, isVersioned := strings.CutPrefix(rt.pattern, prefix)
+		key := rt.method + " " + unprefixed
+		op, ok := ops[key]
+		if !ok {
+			missing = append(missing, rt.method+" "+rt.pattern)
+			continue
+		}
+
+		id := op.Id
+		if !isVersioned && versioned[key] {
+			id += "Unversioned"
+		}
+
+		path := pathParam.ReplaceAllString(rt.pattern, "{$1}")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]operation{}
+		}
+		doc.Paths[path][strings.ToLower(rt.method)] = s.operation(id, rt.pattern, op)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("openapi: routes missing from the spec: %s", strings.Join(missing, ", "))
+	}
+
+	s.components["Error"] = s.object(reflect.TypeOf(errorBody{}))
+	doc.Components = components{
+		Schemas: s.components,
+		SecuritySchemes: map[string]securityScheme{
+			"tma": {
+				Type:        "apiKey",
+				In:          "header",
+				Name:        "Authorization",
+				Description: "Telegram Mini App init data: tma <initData>",
+			},
+		},
+	}
+	return doc, nil
+}
+
+func (s *schemas) operation(id, pattern string, op Operation) operation {
+	o := operation{
+		OperationId: id,
+		Summary:     op.Summary,
+		Deprecated:  op.Deprecated,
+		Responses:   map[string]body{},
+	}
+	if op.Tag != "" {
+		o.Tags = []string{op.Tag}
+	}
+	if op.Public {
+		o.Security = &[]map[string][]string{}
+	}
+
+	for _, m := range pathParam.FindAllStringSubmatch(pattern, -1) {
+		o.Parameters = append(o.Parameters, parameter{
+			Name:     m[1],
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	for _, q := range op.Query {
+		o.Parameters = append(o.Parameters, parameter{
+			Name:        q.Name,
+			In:          "query",
+			Description: q.Description,
+			Schema:      &Schema{Type: q.Type},
+		})
+	}
+	if op.Idempotent {
+		o.Parameters = append(o.Parameters, parameter{
+			Name:        "Idempotency-Key",
+			In:          "header",
+			Description: "Retries with the same key replay the first response",
+			Schema:      &Schema{Type: "string"},
+		})
+	}
+
+	if op.Request != nil {
+		o.RequestBody = &body{
+			Required: true,
+			Content: map[string]mediaType{
+				"application/json": {Schema: s.of(reflect.TypeOf(op.Request))},
+			},
+		}
+	}
+
+	contentType := op.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	ok := body{Description: "OK"}
+	if op.Response != nil {
+		ok.Content = map[string]mediaType{contentType: {Schema: s.of(reflect.TypeOf(op.Response))}}
+	}
+	o.Responses["200"] = ok
+	o.Responses["default"] = body{
+		Description: "Error",
+		Content: map[string]mediaType{
+			"application/json": {Schema: &Schema{Ref: "#/components/schemas/Error"}},
+		},
+	}
+	return o
+}
+
+func (d *Document) Handler() http.HandlerFunc {
+	spec, err := json.Marshal(d)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
+
+//go:embed docs.html
+var docsPage []byte
+
+//go:embed swagger-ui/*.js swagger-ui/*.css
+var swaggerUI embed.FS
+
+// DocsHandler serves a Swagger UI page that renders the spec at /openapi.json.
+func DocsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(docsPage)
+	}
+}
+
+// AssetsHandler serves the Swagger UI script and stylesheet the docs page
+// loads from /docs/.
+func AssetsHandler() http.Handler {
+	assets, err := fs.Sub(swaggerUI, "swagger-ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/docs/", http.FileServer(http.FS(assets)))
+}
@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/myapp/internal/auth"
+	"example.com/myapp/internal/handlers/apierror"
+	"example.com/myapp/internal/logging"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second and holding at
+// most Burst tokens. Every request takes one token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Store keeps the buckets. The in-process MemoryStore is enough for a single
+// replica; replicas that must share limits plug in a store backed by a
+// shared database or cache.
+type Store interface {
+	// Take removes a token from the bucket for key. When the bucket is empty
+	// it reports false and how long until the next token is available.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error)
+}
+
+type KeyFunc func(r *http.Request) string
+
+// ByUser keys requests by the authenticated chat id, falling back to the
+// client address for requests that carry none.
+func ByUser(trustedProxies []netip.Prefix) KeyFunc {
+	return func(r *http.Request) string {
+		if chatId := auth.ChatId(r.Context()); chatId != "" {
+			return "chat:" + chatId
+		}
+		return "ip:" + ClientIP(r, trustedProxies)
+	}
+}
+
+// ByIP keys requests by the client address alone.
+func ByIP(trustedProxies []netip.Prefix) KeyFunc {
+	return func(r *http.Request) string {
+		return "ip:" + ClientIP(r, trustedProxies)
+	}
+}
+
+// ClientIP returns the address of the client. X-Forwarded-For is only
+// honoured when the request comes from a trusted proxy, and is then walked
+// from the right, skipping further trusted hops.
+func ClientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	ip := remote.Addr().Unmap()
+	if !trusted(ip, trustedProxies) {
+		return ip.String()
+	}
+
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+		if !trusted(ip, trustedProxies) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+func trusted(ip netip.Addr, prefixes []netip.Prefix) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware rejects requests over limit with 429 and a Retry-After header.
+// Buckets are namespaced by group so route groups are limited separately.
+// If the store fails the request is let through.
+func Middleware(store Store, group string, limit Limit, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, wait, err := store.Take(r.Context(), group+":"+key(r), limit, time.Now())
+			if err != nil {
+				logging.FromContext(r.Context()).Error("rate limit store failed", "group", group, "err", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			logging.FromContext(r.Context()).Info("rate limited", "group", group, "retry_after", retryAfter)
+			apierror.Write(w, r, apierror.ErrRateLimited.WithDetails(map[string]any{
+				"retry_after": retryAfter,
+			}))
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"example.com/myapp/internal/logging"
+)
+
+var legacySunset = time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+// Deprecated marks a route as deprecated in favour of successor, adding the
+// Deprecation, Sunset and Link headers and logging every call so remaining
+// clients can be tracked down before the route is removed.
+func Deprecated(successor string, sunset time.Time) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logging.FromContext(r.Context()).Warn("deprecated route called",
+				"user_agent", r.UserAgent(), "successor", successor)
+
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Sunset", sunset.Format(http.TimeFormat))
+			w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
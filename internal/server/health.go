@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"example.com/myapp/internal/migrations"
+	"github.com/jmoiron/sqlx"
+)
+
+// Version is set at build time with
+// -ldflags "-X example.com/myapp/internal/server.Version=...".
+var Version = "dev"
+
+const readyTimeout = 2 * time.Second
+
+type Health struct {
+	db           *sqlx.DB
+	shuttingDown atomic.Bool
+}
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+type DatabaseCheck struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type PoolStats struct {
+	MaxOpenConnections int    `json:"max_open_connections"`
+	OpenConnections    int    `json:"open_connections"`
+	InUse              int    `json:"in_use"`
+	Idle               int    `json:"idle"`
+	WaitCount          int64  `json:"wait_count"`
+	WaitDuration       string `json:"wait_duration"`
+	MaxIdleClosed      int64  `json:"max_idle_closed"`
+	MaxLifetimeClosed  int64  `json:"max_lifetime_closed"`
+}
+
+type MigrationStatus struct {
+	Version int    `json:"version"`
+	Latest  int    `json:"latest"`
+	Error   string `json:"error,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Status     string          `json:"status"`
+	Database   DatabaseCheck   `json:"database"`
+	Pool       PoolStats       `json:"pool"`
+	Migrations MigrationStatus `json:"migrations"`
+	Build      BuildInfo       `json:"build"`
+}
+
+func NewHealth(db *sqlx.DB) *Health {
+	return &Health{db: db}
+}
+
+func (h *Health) SetShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+func (h *Health) Liveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func (h *Health) Readiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	resp := ReadinessResponse{
+		Status:     "ok",
+		Database:   h.checkDatabase(ctx),
+		Pool:       h.poolStats(),
+		Migrations: h.migrationStatus(),
+		Build:      buildInfo(),
+	}
+
+	status := http.StatusOK
+	switch {
+	case h.shuttingDown.Load():
+		resp.Status = "shutting_down"
+		status = http.StatusServiceUnavailable
+	case resp.Database.Status != "ok":
+		resp.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *Health) checkDatabase(ctx context.Context) DatabaseCheck {
+	start := time.Now()
+	err := h.db.PingContext(ctx)
+	check := DatabaseCheck{
+		Status:    "ok",
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		check.Status = "error"
+		check.Error = err.Error()
+	}
+	return check
+}
+
+func (h *Health) poolStats() PoolStats {
+	stats := h.db.Stats()
+	return PoolStats{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDuration:       stats.WaitDuration.String(),
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	}
+}
+
+func (h *Health) migrationStatus() MigrationStatus {
+	var status MigrationStatus
+	if all, err := migrations.Load(); err == nil && len(all) > 0 {
+		status.Latest = all[len(all)-1].Version
+	}
+
+	version, err := migrations.Version(h.db)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Version = version
+	return status
+}
+
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: Version}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	info.GoVersion = bi.GoVersion
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.BuildTime = s.Value
+		}
+	}
+	return info
+}
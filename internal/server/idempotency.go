@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"example.com/myapp/internal/auth"
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/handlers"
+	"example.com/myapp/internal/handlers/apierror"
+	"example.com/myapp/internal/logging"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const maxIdempotencyKeyLength = 255
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Idempotency stores the first response to a request carrying an
+// Idempotency-Key header and replays it for retries from the same user.
+// Reusing a key with a different request is rejected with 409. A key whose
+// request failed with a 5xx or a panic is released so the retry runs again.
+func Idempotency(store database.Store, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if key == "" || r.Method == http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				apierror.Write(w, r, apierror.ErrIdempotencyKeyTooLong)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, handlers.MaxBodyBytes))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					apierror.Write(w, r, apierror.ErrBodyTooLarge)
+				} else {
+					apierror.Write(w, r, apierror.ErrBadRequest.WithMessage("Invalid request body"))
+				}
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			chatId := auth.ChatId(r.Context())
+			hash := requestHash(r, body)
+
+			reserved, err := store.Idempotency().ReserveIdempotencyKey(chatId, key, hash, ttl)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("idempotency reserve failed", "key", key, "err", err)
+				apierror.Write(w, r, apierror.ErrInternal)
+				return
+			}
+
+			if !reserved {
+				replayIdempotent(store, w, r, chatId, key, hash)
+				return
+			}
+
+			release := func() {
+				if err := store.Idempotency().ReleaseIdempotencyKey(chatId, key); err != nil {
+					logging.FromContext(r.Context()).Error("idempotency release failed", "key", key, "err", err)
+				}
+			}
+
+			rec := &responseRecorder{ResponseWriter: w}
+			func() {
+				defer func() {
+					if p := recover(); p != nil {
+						release()
+						panic(p)
+					}
+				}()
+				next.ServeHTTP(rec, r)
+			}()
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			if rec.status >= http.StatusInternalServerError {
+				release()
+				return
+			}
+
+			headers, _ := json.Marshal(w.Header())
+			if err := store.Idempotency().CompleteIdempotencyKey(chatId, key, rec.status, string(headers), rec.body.Bytes()); err != nil {
+				logging.FromContext(r.Context()).Error("idempotency complete failed", "key", key, "err", err)
+			}
+		})
+	}
+}
+
+func replayIdempotent(store database.Store, w http.ResponseWriter, r *http.Request, chatId, key, hash string) {
+	record, err := store.Idempotency().GetIdempotencyRecord(chatId, key)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, apierror.ErrIdempotencyInProgress)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("idempotency load failed", "key", key, "err", err)
+		apierror.Write(w, r, apierror.ErrInternal)
+		return
+	}
+
+	if record.RequestHash != hash {
+		apierror.Write(w, r, apierror.ErrIdempotencyKeyReused)
+		return
+	}
+
+	if record.StatusCode == nil {
+		apierror.Write(w, r, apierror.ErrIdempotencyInProgress)
+		return
+	}
+
+	if record.Headers != nil {
+		var headers http.Header
+		if err := json.Unmarshal([]byte(*record.Headers), &headers); err == nil {
+			for k, v := range headers {
+				if k == middleware.RequestIDHeader {
+					continue
+				}
+				w.Header()[k] = v
+			}
+		}
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(*record.StatusCode)
+	w.Write(record.Body)
+}
+
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
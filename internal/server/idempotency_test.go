@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/myapp/internal/auth"
+	"example.com/myapp/internal/auth/authtest"
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/handlers"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func idempotentRequest(h http.Handler, path, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.Header.Set("Authorization", "tma "+authtest.InitData("42"))
+	req.Header.Set("Idempotency-Key", key)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotencyReplaysCaseOpen(t *testing.T) {
+	store := database.NewMemoryStore()
+	store.PutUser(database.User{ChatId: "42", Chests: 3})
+	game := config.Game{CaseGemsMin: 1, CaseGemsMax: 10, CaseBalanceMin: 1000, CaseBalanceMax: 10000}
+
+	r := chi.NewRouter()
+	r.Use(auth.Middleware(authtest.BotToken, time.Hour))
+	r.Use(Idempotency(store, time.Hour))
+	r.Post("/case/open/{chatId}", handlers.OpenCaseHandler(store, game))
+
+	first := idempotentRequest(r, "/case/open/42", "open-1")
+	if first.Code != http.StatusOK {
+		t.Fatalf("first request: status %d: %s", first.Code, first.Body)
+	}
+	second := idempotentRequest(r, "/case/open/42", "open-1")
+	if second.Code != http.StatusOK {
+		t.Fatalf("replay: status %d: %s", second.Code, second.Body)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay is missing the Idempotent-Replayed header")
+	}
+	if second.Body.String() != first.Body.String() {
+		t.Errorf("replay body = %s, want %s", second.Body, first.Body)
+	}
+
+	user, err := store.Users().GetUser("42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Chests != 2 {
+		t.Errorf("chests = %d, want 2", user.Chests)
+	}
+}
+
+func TestIdempotencyReleasesKeyOnPanic(t *testing.T) {
+	store := database.NewMemoryStore()
+	var calls int
+
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Use(auth.Middleware(authtest.BotToken, time.Hour))
+	r.Use(Idempotency(store, time.Hour))
+	r.Post("/panic", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	if w := idempotentRequest(r, "/panic", "panic-1"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("panicking request: status %d, want 500", w.Code)
+	}
+	if w := idempotentRequest(r, "/panic", "panic-1"); w.Code != http.StatusOK {
+		t.Fatalf("retry: status %d, want 200", w.Code)
+	}
+	if calls != 2 {
+		t.Errorf("handler ran %d times, want 2", calls)
+	}
+}
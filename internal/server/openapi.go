@@ -0,0 +1,150 @@
+package server
+
+import (
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/handlers"
+	"example.com/myapp/internal/openapi"
+)
+
+// operations documents every route in Routes, keyed by method and pattern
+// without the /v1 prefix. Routes fails to build if a route is missing here.
+var operations = map[string]openapi.Operation{
+	"GET /healthz": {
+		Id: "liveness", Summary: "Liveness probe", Tag: "ops", Public: true,
+		Response: map[string]string{},
+	},
+	"GET /readyz": {
+		Id: "readiness", Summary: "Readiness probe with database, pool and migration status", Tag: "ops", Public: true,
+		Response: ReadinessResponse{},
+	},
+	"GET /metrics": {
+		Id: "metrics", Summary: "Prometheus metrics", Tag: "ops", Public: true,
+		Response: "", ContentType: "text/plain",
+	},
+	"GET /openapi.json": {
+		Id: "openapi", Summary: "This document", Tag: "ops", Public: true,
+		Response: map[string]any{},
+	},
+	"GET /docs": {
+		Id: "docs", Summary: "Swagger UI for this document", Tag: "ops", Public: true,
+		Response: "", ContentType: "text/html",
+	},
+	"GET /docs/{asset}": {
+		Id: "docsAsset", Summary: "Swagger UI script or stylesheet", Tag: "ops", Public: true,
+	},
+
+	"GET /user/{chatId}": {
+		Id: "getUser", Summary: "Get the authenticated user", Tag: "users",
+		Response: database.User{},
+	},
+	"GET /user/{chatId}/ledger": {
+		Id: "getUserLedger", Summary: "List ledger entries, newest first", Tag: "users",
+		Query: []openapi.Param{
+			{Name: "cursor", Type: "string", Description: "next_cursor from the previous page"},
+			{Name: "limit", Type: "integer", Description: "Page size, 1 to 200, default 50"},
+		},
+		Response: handlers.LedgerResponse{},
+	},
+	"POST /case/open/{chatId}": {
+		Id: "openCase", Summary: "Spend a chest to open a case", Tag: "case", Idempotent: true,
+		Response: handlers.CaseOpenResponse{},
+	},
+
+	"GET /mining/getSlots/{userId}": {
+		Id: "getSlots", Summary: "List the user's stands", Tag: "mining",
+		Response: []handlers.SlotResponse{},
+	},
+	"GET /mining/getGpu/{userId}": {
+		Id: "getGpu", Summary: "List the user's GPUs with their income", Tag: "mining",
+		Response: []handlers.CardWithIncome{},
+	},
+	"GET /mining/getGpuById/{gpuId}": {
+		Id: "getGpuById", Summary: "Get a GPU", Tag: "mining",
+		Response: database.Card{},
+	},
+	"POST /mining/installGpu": {
+		Id: "installGpu", Summary: "Install a GPU into a stand", Tag: "mining", Idempotent: true,
+		Request: handlers.InstallGpuRequest{}, Response: handlers.StatusResponse{},
+	},
+	"POST /mining/buySlot/{userId}": {
+		Id: "buySlot", Summary: "Buy a stand", Tag: "mining", Idempotent: true,
+		Response: handlers.BuySlotResponse{},
+	},
+	"POST /mining/freezeGpu": {
+		Id: "freezeGpu", Summary: "Spend a freeze to refuel a GPU", Tag: "mining", Idempotent: true,
+		Request: handlers.FreezeGpuRequest{}, Response: handlers.FreezeGpuResponse{},
+	},
+	"POST /mining/cards/{cardId}/withdraw": {
+		Id: "withdrawCard", Summary: "Move the whole coins on a GPU to the user", Tag: "mining", Idempotent: true,
+		Response: handlers.WithdrawResponse{},
+	},
+	"POST /mining/cards/{cardId}/upgrade": {
+		Id: "upgradeCard", Summary: "Pay to raise a GPU's level by one", Tag: "mining", Idempotent: true,
+		Response: handlers.UpgradeGpuResponse{},
+	},
+	"POST /mining/cards/fuse": {
+		Id: "fuseCards", Summary: "Fuse two or more (GAME_FUSE_CARDS) uninstalled GPUs of one level into the first; the rest are consumed even if the roll fails", Tag: "mining", Idempotent: true,
+		Request: handlers.FuseGpuRequest{}, Response: handlers.FuseGpuResponse{},
+	},
+	"DELETE /mining/stands/{standId}/card": {
+		Id: "removeStandCard", Summary: "Remove the GPU from a stand", Tag: "mining", Idempotent: true,
+		Response: handlers.StatusResponse{},
+	},
+
+	"GET /market/listings": {
+		Id: "listListings", Summary: "Browse cards for sale", Tag: "market",
+		Query: []openapi.Param{
+			{Name: "currency", Type: "string", Description: "coin or balance"},
+			{Name: "min_level", Type: "integer"},
+			{Name: "max_level", Type: "integer"},
+			{Name: "min_price", Type: "integer"},
+			{Name: "max_price", Type: "integer"},
+			{Name: "sort", Type: "string", Description: "newest (default), price_asc or price_desc"},
+			{Name: "cursor", Type: "string", Description: "next_cursor from the previous page, with the same sort"},
+			{Name: "limit", Type: "integer", Description: "Page size, 1 to 200, default 50"},
+		},
+		Response: handlers.ListingsResponse{},
+	},
+	"POST /market/listings": {
+		Id: "createListing", Summary: "Put an uninstalled card up for sale", Tag: "market", Idempotent: true,
+		Request: handlers.CreateListingRequest{}, Response: handlers.ListingResponse{},
+	},
+	"POST /market/listings/{listingId}/buy": {
+		Id: "buyListing", Summary: "Buy a listed card; the seller is paid the price less the market fee", Tag: "market", Idempotent: true,
+		Response: handlers.BuyListingResponse{},
+	},
+	"DELETE /market/listings/{listingId}": {
+		Id: "cancelListing", Summary: "Take a card off the market", Tag: "market", Idempotent: true,
+		Response: handlers.StatusResponse{},
+	},
+
+	"GET /trades": {
+		Id: "listTrades", Summary: "List pending trades sent or received, newest first", Tag: "trades",
+		Response: handlers.TradesResponse{},
+	},
+	"POST /trades": {
+		Id: "createTrade", Summary: "Offer a trade; offered currency is held in escrow and offered cards are locked until it closes or expires", Tag: "trades", Idempotent: true,
+		Request: handlers.CreateTradeRequest{}, Response: handlers.TradeResponse{},
+	},
+	"POST /trades/{tradeId}/accept": {
+		Id: "acceptTrade", Summary: "Accept a trade sent to you and swap both sides", Tag: "trades", Idempotent: true,
+		Response: handlers.TradeResponse{},
+	},
+	"POST /trades/{tradeId}/reject": {
+		Id: "rejectTrade", Summary: "Reject a trade sent to you and refund the sender", Tag: "trades", Idempotent: true,
+		Response: handlers.TradeResponse{},
+	},
+	"DELETE /trades/{tradeId}": {
+		Id: "cancelTrade", Summary: "Withdraw a trade you sent and refund the escrow", Tag: "trades", Idempotent: true,
+		Response: handlers.TradeResponse{},
+	},
+
+	"GET /mining/withdrowBitcoin/{cardId}/{userId}": {
+		Id: "withdrowBitcoin", Summary: "Use POST /v1/mining/cards/{cardId}/withdraw", Tag: "mining", Deprecated: true,
+		Response: handlers.WithdrawResponse{},
+	},
+	"GET /mining/pullGpu/{gpuId}/{userId}": {
+		Id: "pullGpu", Summary: "Use DELETE /v1/mining/stands/{standId}/card", Tag: "mining", Deprecated: true,
+		Response: handlers.StatusResponse{},
+	},
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"example.com/myapp/internal/config"
+)
+
+func TestSIGTERMDrainsInFlightRequests(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+
+	ctx, stop := NotifyShutdown(context.Background())
+	defer stop()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- serve(ctx, ln, config.HTTP{ShutdownTimeout: 5 * time.Second}, handler)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	res := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			res <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		res <- result{string(body), err}
+	}()
+
+	<-started
+	if err := sigterm(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-runErr:
+		t.Fatalf("Run returned %v with a request in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	got := <-res
+	if got.err != nil || got.body != "done" {
+		t.Fatalf("in-flight request: body %q, err %v", got.body, got.err)
+	}
+
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("Run returned %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the request finished")
+	}
+}
+
+// sigterm sends SIGTERM to the test process. NotifyShutdown has taken over
+// the signal, so it cancels the context instead of killing the process.
+func sigterm() error {
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		return err
+	}
+	return p.Signal(syscall.SIGTERM)
+}
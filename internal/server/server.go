@@ -1,34 +1,144 @@
 package server
 
 import (
+	"log/slog"
+	"net/http"
+
+	"example.com/myapp/internal/auth"
+	"example.com/myapp/internal/config"
+	"example.com/myapp/internal/database"
 	"example.com/myapp/internal/handlers"
+	"example.com/myapp/internal/handlers/apierror"
+	"example.com/myapp/internal/logging"
+	"example.com/myapp/internal/metrics"
+	"example.com/myapp/internal/openapi"
+	"example.com/myapp/internal/ratelimit"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/jmoiron/sqlx"
 )
 
-func Routes(db *sqlx.DB) *chi.Mux {
+// Routes builds the router and the OpenAPI document describing it. It fails
+// if a route has no entry in operations.
+func Routes(cfg config.Config, db *sqlx.DB, health *Health) (*chi.Mux, error) {
+	store := database.NewMySQLStore(db)
+	limits := newRouteLimits(cfg.RateLimit)
 	r := chi.NewRouter()
 
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST"},
-		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type"},
-	}))
-	r.Use(middleware.Logger)
+	r.Use(corsHandler(cfg.CORS))
+	r.Use(middleware.RequestID)
+	r.Use(logging.Middleware(slog.Default()))
+	r.Use(metrics.Middleware)
 	r.Use(middleware.Recoverer)
 
-	r.Get("/user/{chatId}", handlers.GetUserHandler(db))
-	r.Get("/mining/withdrowBitcoin/{cardId}/{userId}", handlers.WithdrawBitcoinHandler(db))
-	r.Get("/mining/getSlots/{userId}", handlers.GetSlotsHandler(db))
-	r.Get("/mining/getGpu/{userId}", handlers.GetGpuHandler(db))
-	r.Get("/mining/getGpuById/{gpuId}", handlers.GetGpuByIdHandler(db))
-	r.Get("/mining/pullGpu/{gpuId}/{userId}", handlers.PullGpuHandler(db))
-	r.Post("/case/open/{chatId}", handlers.OpenCaseHandler(db))
-	r.Post("/mining/installGpu", handlers.InstallGpuHandler(db))
-	r.Post("/mining/buySlot/{userId}", handlers.BuySlotHandler(db))
-	r.Post("/mining/freezeGpu", handlers.FreezeGpuHandler(db))
-
-	return r
+	r.Get("/healthz", health.Liveness)
+	r.Get("/readyz", health.Readiness)
+	r.Method(http.MethodGet, "/metrics", metrics.Handler())
+
+	var spec http.HandlerFunc
+	r.Get("/openapi.json", func(w http.ResponseWriter, r *http.Request) { spec(w, r) })
+	r.Get("/docs", openapi.DocsHandler())
+	r.Method(http.MethodGet, "/docs/{asset}", openapi.AssetsHandler())
+
+	r.Group(func(r chi.Router) {
+		// Limit by address first so floods without valid initData are
+		// turned away before they cost a signature check.
+		r.Use(limits.ip)
+		r.Use(auth.Middleware(cfg.BotToken, cfg.AuthMaxAge))
+
+		r.Route("/v1", func(r chi.Router) {
+			v1Routes(r, cfg, store, limits)
+		})
+
+		// Unversioned paths predate /v1 and stay mounted for existing clients.
+		r.Group(func(r chi.Router) {
+			v1Routes(r, cfg, store, limits)
+			legacyRoutes(r, store, limits)
+		})
+	})
+
+	doc, err := openapi.Build(openapi.Info{Title: "Mining game API", Version: Version}, r, "/v1", operations)
+	if err != nil {
+		return nil, err
+	}
+	spec = doc.Handler()
+
+	return r, nil
+}
+
+// corsHandler allows the request headers the API itself relies on plus any
+// extra ones configured in CORS_HEADERS.
+func corsHandler(cfg config.CORS) func(http.Handler) http.Handler {
+	headers := []string{"Accept", "Authorization", "Content-Type", "Idempotency-Key", "X-Request-Id", apierror.LegacyHeader}
+	return cors.Handler(cors.Options{
+		AllowedOrigins:   cfg.Origins,
+		AllowedMethods:   cfg.Methods,
+		AllowedHeaders:   append(headers, cfg.Headers...),
+		ExposedHeaders:   []string{"Deprecation", "Sunset", "Link", "Retry-After", "X-Request-Id"},
+		AllowCredentials: cfg.AllowCredentials,
+		MaxAge:           int(cfg.MaxAge.Seconds()),
+	})
+}
+
+// routeLimits holds the per-address limiter in front of authentication and
+// one per-user limiter per route group. The limiters share a store, so the
+// same group mounted under /v1 and at the root counts against one bucket.
+type routeLimits struct {
+	ip      func(http.Handler) http.Handler
+	read    func(http.Handler) http.Handler
+	economy func(http.Handler) http.Handler
+}
+
+func newRouteLimits(cfg config.RateLimit) routeLimits {
+	store := ratelimit.NewMemoryStore()
+	key := ratelimit.ByUser(cfg.TrustedProxies)
+	return routeLimits{
+		ip:      ratelimit.Middleware(store, "ip", ratelimit.Limit(cfg.IP), ratelimit.ByIP(cfg.TrustedProxies)),
+		read:    ratelimit.Middleware(store, "read", ratelimit.Limit(cfg.Read), key),
+		economy: ratelimit.Middleware(store, "economy", ratelimit.Limit(cfg.Economy), key),
+	}
+}
+
+func v1Routes(r chi.Router, cfg config.Config, store database.Store, limits routeLimits) {
+	r.Group(func(r chi.Router) {
+		r.Use(limits.read)
+
+		r.Get("/user/{chatId}", handlers.GetUserHandler(store))
+		r.Get("/user/{chatId}/ledger", handlers.GetUserLedgerHandler(store))
+		r.Get("/mining/getSlots/{userId}", handlers.GetSlotsHandler(store, cfg.Game))
+		r.Get("/mining/getGpu/{userId}", handlers.GetGpuHandler(store, cfg.Game))
+		r.Get("/mining/getGpuById/{gpuId}", handlers.GetGpuByIdHandler(store))
+		r.Get("/market/listings", handlers.ListListingsHandler(store, cfg.Game))
+		r.Get("/trades", handlers.GetTradesHandler(store))
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(limits.economy)
+		r.Use(Idempotency(store, cfg.IdempotencyTTL))
+
+		r.Post("/case/open/{chatId}", handlers.OpenCaseHandler(store, cfg.Game))
+		r.Post("/mining/installGpu", handlers.InstallGpuHandler(store))
+		r.Post("/mining/buySlot/{userId}", handlers.BuySlotHandler(store, cfg.Game))
+		r.Post("/mining/freezeGpu", handlers.FreezeGpuHandler(store, cfg.Game))
+		r.Post("/mining/cards/{cardId}/withdraw", handlers.WithdrawBitcoinHandler(store))
+		r.Post("/mining/cards/fuse", handlers.FuseGpuHandler(store, cfg.Game))
+		r.Post("/mining/cards/{cardId}/upgrade", handlers.UpgradeGpuHandler(store, cfg.Game))
+		r.Delete("/mining/stands/{standId}/card", handlers.RemoveStandCardHandler(store))
+		r.Post("/market/listings", handlers.CreateListingHandler(store, cfg.Game))
+		r.Post("/market/listings/{listingId}/buy", handlers.BuyListingHandler(store, cfg.Game))
+		r.Delete("/market/listings/{listingId}", handlers.CancelListingHandler(store))
+		r.Post("/trades", handlers.CreateTradeHandler(store, cfg.Game))
+		r.Post("/trades/{tradeId}/accept", handlers.AcceptTradeHandler(store))
+		r.Post("/trades/{tradeId}/reject", handlers.RejectTradeHandler(store))
+		r.Delete("/trades/{tradeId}", handlers.CancelTradeHandler(store))
+	})
+}
+
+func legacyRoutes(r chi.Router, store database.Store, limits routeLimits) {
+	r = r.With(limits.economy)
+	r.With(Deprecated("/v1/mining/cards/{cardId}/withdraw", legacySunset)).
+		Get("/mining/withdrowBitcoin/{cardId}/{userId}", handlers.WithdrawBitcoinHandler(store))
+	r.With(Deprecated("/v1/mining/stands/{standId}/card", legacySunset)).
+		Get("/mining/pullGpu/{gpuId}/{userId}", handlers.PullGpuHandler(store))
 }
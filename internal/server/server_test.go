@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/myapp/internal/auth/authtest"
+	"example.com/myapp/internal/config"
+)
+
+// testConfig is a valid config for building the router in tests.
+func testConfig() config.Config {
+	limit := config.Limit{Rate: 100, Burst: 100}
+	return config.Config{
+		BotToken:   authtest.BotToken,
+		AuthMaxAge: time.Hour,
+		RateLimit:  config.RateLimit{IP: limit, Read: limit, Economy: limit},
+	}
+}
+
+func TestUnauthenticatedRequestsAreRateLimited(t *testing.T) {
+	cfg := testConfig()
+	cfg.RateLimit.IP = config.Limit{Rate: 0.001, Burst: 2}
+	router, err := Routes(cfg, nil, NewHealth(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/user/42", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Fatalf("request %d: status %d, want %d", i+1, w.Code, want)
+		}
+	}
+
+	// Another address has its own bucket.
+	req := httptest.NewRequest(http.MethodGet, "/v1/user/42", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("other address: status %d, want 401", w.Code)
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	h := corsHandler(config.CORS{
+		Origins: []string{"https://app.example.com", "https://*.example.org"},
+		Methods: []string{http.MethodGet, http.MethodPost},
+		MaxAge:  10 * time.Minute,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("preflight reached the handler")
+	}))
+
+	tests := []struct {
+		name    string
+		origin  string
+		method  string
+		allowed bool
+	}{
+		{"listed origin", "https://app.example.com", http.MethodPost, true},
+		{"unlisted origin", "https://evil.example.com", http.MethodPost, false},
+		{"wildcard subdomain", "https://game.example.org", http.MethodPost, true},
+		{"wildcard nested subdomain", "https://a.b.example.org", http.MethodPost, true},
+		{"wildcard needs a subdomain", "https://example.org", http.MethodPost, false},
+		{"wildcard keeps the scheme", "http://game.example.org", http.MethodPost, false},
+		{"wildcard suffix only", "https://game.example.org.evil.com", http.MethodPost, false},
+		{"method not allowed", "https://app.example.com", http.MethodDelete, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, "/v1/market/listings", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			req.Header.Set("Access-Control-Request-Headers", "Authorization, Idempotency-Key")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			got := w.Header().Get("Access-Control-Allow-Origin")
+			if !tt.allowed {
+				if got != "" {
+					t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+				}
+				return
+			}
+			if got != tt.origin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.origin)
+			}
+			if allow := w.Header().Get("Access-Control-Allow-Headers"); allow != "Authorization, Idempotency-Key" {
+				t.Errorf("Access-Control-Allow-Headers = %q", allow)
+			}
+			if maxAge := w.Header().Get("Access-Control-Max-Age"); maxAge != "600" {
+				t.Errorf("Access-Control-Max-Age = %q, want 600", maxAge)
+			}
+		})
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	router, err := Routes(testConfig(), nil, NewHealth(nil))
+	if err != nil {
+		t.Fatalf("Routes: %v", err)
+	}
+
+	tests := []struct {
+		path        string
+		contentType string
+	}{
+		{"/openapi.json", "application/json"},
+		{"/docs", "text/html; charset=utf-8"},
+		{"/docs/swagger-ui-bundle.js", "text/javascript; charset=utf-8"},
+		{"/docs/swagger-ui.css", "text/css; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s: status %d", tt.path, w.Code)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("GET %s: Content-Type %q, want %q", tt.path, ct, tt.contentType)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package trade
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/metrics"
+)
+
+const sweepBatch = 100
+
+// Sweeper expires pending trades past their deadline and refunds the
+// escrow. Each trade is re-checked under its row lock, so every replica may
+// run one.
+type Sweeper struct {
+	store    database.Store
+	interval time.Duration
+}
+
+func NewSweeper(store database.Store, interval time.Duration) *Sweeper {
+	return &Sweeper{store: store, interval: interval}
+}
+
+// Run sweeps every interval until ctx is cancelled.
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		expired, err := s.Sweep(time.Now())
+		if err != nil {
+			slog.Error("trade: sweep failed", "err", err)
+		} else if expired > 0 {
+			slog.Info("trade: expired offers", "count", expired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep expires every trade due as of now and returns how many it expired.
+func (s *Sweeper) Sweep(now time.Time) (int, error) {
+	var expired int
+	for {
+		ids, err := s.store.Trades().GetExpiredTradeIds(now, sweepBatch)
+		if err != nil {
+			return expired, err
+		}
+
+		for _, id := range ids {
+			var released bool
+			err := s.store.WithTx(func(tx database.Store) error {
+				t, err := tx.Trades().GetTradeForUpdate(id)
+				if err != nil {
+					return err
+				}
+				if t.Status != database.TradePending || t.ExpiresAt.After(now) {
+					return nil
+				}
+				released = true
+				return Release(tx, t, database.TradeExpired)
+			})
+			if err != nil {
+				return expired, err
+			}
+			if released {
+				expired++
+				metrics.Trade(string(database.TradeExpired))
+			}
+		}
+
+		if len(ids) < sweepBatch {
+			return expired, nil
+		}
+	}
+}
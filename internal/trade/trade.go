@@ -0,0 +1,108 @@
+// Package trade moves the assets of trade offers. A sender's currency goes
+// into escrow on the trade's ledger account when the offer is made and
+// comes back if it is rejected, cancelled or expires. Offered cards stay
+// with the sender but may not change while the offer is pending.
+package trade
+
+import (
+	"strconv"
+
+	"example.com/myapp/internal/database"
+	"example.com/myapp/internal/ledger"
+)
+
+// Escrow takes the currencies t offers from its sender.
+func Escrow(tx database.Store, t database.TradeOffer) error {
+	for _, item := range currencies(t, database.TradeOffered) {
+		err := ledger.Apply(tx, posting(t, t.FromUserId, item, -item.Amount, ledger.ReasonTradeEscrow))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release returns the escrow to the sender and closes t with status.
+func Release(tx database.Store, t database.TradeOffer, status database.TradeStatus) error {
+	for _, item := range currencies(t, database.TradeOffered) {
+		err := ledger.Apply(tx, posting(t, t.FromUserId, item, item.Amount, ledger.ReasonTradeRefund))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Trades().UpdateTradeStatus(t.Id, status)
+}
+
+// Settle swaps both sides of t. The caller must have checked and locked
+// the cards. The whole coins on a card are paid to its old owner.
+func Settle(tx database.Store, t database.TradeOffer) error {
+	var postings []ledger.Posting
+	for _, item := range currencies(t, database.TradeOffered) {
+		postings = append(postings, posting(t, t.ToUserId, item, item.Amount, ledger.ReasonTradeSettle))
+	}
+	for _, item := range currencies(t, database.TradeRequested) {
+		postings = append(postings,
+			posting(t, t.ToUserId, item, -item.Amount, ledger.ReasonTradeSettle),
+			posting(t, t.FromUserId, item, item.Amount, ledger.ReasonTradeSettle))
+	}
+
+	type move struct {
+		card database.Card
+		to   int
+	}
+	var moves []move
+	for _, item := range t.Items {
+		if item.CardId == nil {
+			continue
+		}
+		card, err := tx.Cards().GetCardByIdForUpdate(*item.CardId)
+		if err != nil {
+			return err
+		}
+		to := t.ToUserId
+		if item.Side == database.TradeRequested {
+			to = t.FromUserId
+		}
+		moves = append(moves, move{card, to})
+		payout, err := ledger.CardPayout(tx, card, card.UserId, ledger.ReasonTradeCardPayout)
+		if err != nil {
+			return err
+		}
+		postings = append(postings, payout)
+	}
+
+	if err := ledger.ApplyAll(tx, postings); err != nil {
+		return err
+	}
+
+	for _, m := range moves {
+		if err := tx.Cards().UpdateCardOwner(m.card.Id, m.to); err != nil {
+			return err
+		}
+		if err := tx.Market().DelistCard(m.card.Id); err != nil {
+			return err
+		}
+	}
+	return tx.Trades().UpdateTradeStatus(t.Id, database.TradeAccepted)
+}
+
+func currencies(t database.TradeOffer, side database.TradeSide) []database.TradeItem {
+	var items []database.TradeItem
+	for _, item := range t.Items {
+		if item.Side == side && item.Currency != nil && item.Amount > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func posting(t database.TradeOffer, userId int, item database.TradeItem, delta int64, reason ledger.Reason) ledger.Posting {
+	return ledger.Posting{
+		UserId:       userId,
+		Currency:     *item.Currency,
+		Delta:        delta,
+		Reason:       reason,
+		ReferenceId:  strconv.FormatInt(t.Id, 10),
+		Counterparty: ledger.TradeAccount(t.Id),
+	}
+}
@@ -0,0 +1,159 @@
+// Package validate checks structs against rules in their validate tags:
+//
+//	CardId int `json:"cardId" validate:"required,min=1"`
+//
+// required rejects zero values. min and max bound numbers, and the length of
+// strings and slices. Fields are reported by their JSON name.
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type Rule struct {
+	Name  string
+	Value float64
+}
+
+// Struct validates v, which must be a struct or a pointer to one, and
+// returns one error per invalid field.
+func Struct(v any) []FieldError {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validate: %T is not a struct", v))
+	}
+	return structFields(rv, "")
+}
+
+func structFields(rv reflect.Value, prefix string) []FieldError {
+	var errs []FieldError
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := prefix + JSONName(f)
+		fv := rv.Field(i)
+
+		if err, ok := field(fv, name, Rules(f)); !ok {
+			errs = append(errs, err)
+			continue
+		}
+
+		if fv.Kind() == reflect.Pointer && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct {
+			errs = append(errs, structFields(fv, name+".")...)
+		}
+	}
+	return errs
+}
+
+// field applies the rules in order and stops at the first one that fails.
+func field(v reflect.Value, name string, rules []Rule) (FieldError, bool) {
+	for _, rule := range rules {
+		switch rule.Name {
+		case "required":
+			if v.IsZero() {
+				return FieldError{Field: name, Rule: rule.Name, Message: "is required"}, false
+			}
+		case "min", "max":
+			if v.Kind() == reflect.Pointer {
+				if v.IsNil() {
+					continue
+				}
+				v = v.Elem()
+			}
+			n, isLength := measure(v)
+			if (rule.Name == "min" && n >= rule.Value) || (rule.Name == "max" && n <= rule.Value) {
+				continue
+			}
+
+			word := "at least"
+			if rule.Name == "max" {
+				word = "at most"
+			}
+			bound := strconv.FormatFloat(rule.Value, 'f', -1, 64)
+
+			var msg string
+			switch {
+			case !isLength:
+				msg = fmt.Sprintf("must be %s %s", word, bound)
+			case v.Kind() == reflect.String:
+				msg = fmt.Sprintf("must have %s %s characters", word, bound)
+			default:
+				msg = fmt.Sprintf("must have %s %s items", word, bound)
+			}
+			return FieldError{Field: name, Rule: rule.Name, Message: msg}, false
+		}
+	}
+	return FieldError{}, true
+}
+
+// measure returns the number min and max compare against and whether it is
+// a length rather than the value itself.
+func measure(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(v.Len()), true
+	default:
+		return 0, false
+	}
+}
+
+// Rules parses the validate tag of f. An unknown rule or a bad bound is a
+// programming error and panics.
+func Rules(f reflect.StructField) []Rule {
+	tag := f.Tag.Get("validate")
+	if tag == "" {
+		return nil
+	}
+
+	var rules []Rule
+	for _, item := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(item, "=")
+		rule := Rule{Name: name}
+		switch name {
+		case "required":
+		case "min", "max":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic(fmt.Sprintf("validate: field %s: bad %s bound %q", f.Name, name, arg))
+			}
+			rule.Value = n
+		default:
+			panic(fmt.Sprintf("validate: field %s: unknown rule %q", f.Name, name))
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// JSONName returns the name encoding/json uses for f.
+func JSONName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}